@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"time"
+)
+
+// Entry records one change made through the bot. Before and After are human
+// readable values of whatever changed, empty when it didn't exist.
+type Entry struct {
+	ID     uint64
+	At     time.Time
+	Actor  string
+	Action string
+	Target string
+	Before string
+	After  string
+}
+
+// Repo is an append-only store of entries.
+type Repo interface {
+	Append(e Entry) error
+	// Entries returns up to n entries, newest first, that involve userID as
+	// actor or target. An empty userID matches every entry.
+	Entries(userID string, n int) ([]Entry, error)
+}
+
+var repo Repo
+
+func SetRepo(r Repo) {
+	repo = r
+}
+
+var now = time.Now
+
+// Record appends an entry timestamped now. Nothing is recorded until SetRepo
+// has been called.
+func Record(actor, action, target, before, after string) error {
+	if repo == nil {
+		return nil
+	}
+	return repo.Append(Entry{At: now(), Actor: actor, Action: action, Target: target, Before: before, After: after})
+}
+
+func Entries(userID string, n int) ([]Entry, error) {
+	if repo == nil {
+		return []Entry{}, nil
+	}
+	return repo.Entries(userID, n)
+}
+
+func (e Entry) Involves(userID string) bool {
+	return len(userID) == 0 || e.Actor == userID || e.Target == userID
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+func NewBoltRepo(b *bolt.DB) *BoltAuditRepo {
+	return &BoltAuditRepo{b}
+}
+
+// BoltAuditRepo keys entries by sequence so they're kept in the order they
+// were appended. There's deliberately no way to change or remove them.
+type BoltAuditRepo struct {
+	*bolt.DB
+}
+
+var bucket = []byte("audit")
+
+func (r *BoltAuditRepo) Append(e Entry) error {
+	return r.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		e.ID, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		se, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, e.ID)
+		return bucket.Put(key, se)
+	})
+}
+
+func (r *BoltAuditRepo) Entries(userID string, n int) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	err := r.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < n; k, v = c.Prev() {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if e.Involves(userID) {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestBoltRepoAppendsEntries(t *testing.T) {
+	f, err := ioutil.TempFile("", "audit")
+	ok(t, err)
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	ok(t, err)
+	defer os.Remove(f.Name())
+	defer db.Close()
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return jan1st2015 }
+	defer func() { now = time.Now }()
+
+	entries, err := Entries("", 10)
+	ok(t, err)
+	equals(t, 0, len(entries))
+
+	SetRepo(NewBoltRepo(db))
+	defer SetRepo(nil)
+	ok(t, Record("batman", "grant", "robin", "[]", "[okr]"))
+	ok(t, Record("batman", "toggle flag", "joker", "[]", "[villain]"))
+	ok(t, Record("robin", "add role", "okr", "", "okrs.export"))
+
+	entries, err = Entries("", 10)
+	ok(t, err)
+	equals(t, 3, len(entries))
+	equals(t, Entry{3, jan1st2015, "robin", "add role", "okr", "", "okrs.export"}, entries[0])
+	equals(t, uint64(1), entries[2].ID)
+
+	entries, err = Entries("robin", 10)
+	ok(t, err)
+	equals(t, 2, len(entries))
+	equals(t, "add role", entries[0].Action)
+	equals(t, "grant", entries[1].Action)
+
+	entries, err = Entries("", 1)
+	ok(t, err)
+	equals(t, 1, len(entries))
+}
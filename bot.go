@@ -1,7 +1,12 @@
 package bot
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mackross/go-bot/chat"
@@ -12,54 +17,240 @@ type MessageHandler interface {
 	HandleMessage(b *Bot, m chat.InMsg) bool
 }
 
+// PoppedChildHandler is told when a handler it pushed is popped, why, and
+// with what value.
 type PoppedChildHandler interface {
-	ChildPopped(b *Bot, child MessageHandler, id int)
+	ChildPopped(b *Bot, child MessageHandler, id int, result cmd.Result)
 }
 
+// PoppedHandler is told whenever it's popped and why, even when it was pushed
+// without a parent.
+type PoppedHandler interface {
+	Popped(b *Bot, result cmd.Result)
+}
+
+// Bot handles messages from its own network and any others attached to it.
+// Replies are routed back to the network a message arrived on.
 type Bot struct {
 	chat.Network
 
-	cmdStack   *cmd.Stack
-	handlerMap map[MessageHandler]*commandWrapper
-	logging    bool
+	cmdStack       *cmd.Stack
+	handlerMap     map[MessageHandler]*commandWrapper
+	roots          []MessageHandler
+	middleware     []Middleware
+	handlerMu      sync.Mutex
+	networks       map[string]chat.Network
+	networksMu     sync.RWMutex
+	store          cmd.Store
+	storeMu        sync.Mutex
+	expiring       sync.Once
+	expiryInterval time.Duration
+	dispatchMu     sync.Mutex
+	handledBy      MessageHandler // guarded by dispatchMu
+	stop           chan struct{}
+	stopOnce       sync.Once
+	cancel         string
 }
 
 func (b *Bot) AddRootHandler(obj MessageHandler) {
+	b.handlerMu.Lock()
+	b.roots = append(b.roots, obj)
+	b.handlerMu.Unlock()
 	b.cmdStack.AddRoot(b.wrappedHandler(obj))
 }
 
+// PushHandler pushes obj into the conversation of parent. Without a pushed
+// parent obj sees messages from every conversation.
 func (b *Bot) PushHandler(obj MessageHandler, parent MessageHandler) int {
 	return b.cmdStack.PushCmd(b.wrappedHandler(obj), b.wrappedHandler(parent))
 }
 
+// PushHandlerFor pushes obj so it only sees messages from the conversation m
+// is part of, see ConversationKey.
+func (b *Bot) PushHandlerFor(m chat.InMsg, obj MessageHandler, parent MessageHandler) int {
+	return b.cmdStack.PushCmdFor(ConversationKey(m), b.wrappedHandler(obj), b.wrappedHandler(parent))
+}
+
+// DefaultCancelPhrase is what a user says to abandon the handlers pushed for
+// their conversation.
+const DefaultCancelPhrase = "cancel"
+
+// SetCancelPhrase changes what a user says to abandon the handlers pushed for
+// their conversation. Its case is ignored and an empty phrase turns cancelling
+// off. The handlers that started the conversation are told their child was
+// popped with cmd.Cancelled.
+func (b *Bot) SetCancelPhrase(phrase string) {
+	b.handlerMu.Lock()
+	defer b.handlerMu.Unlock()
+	b.cancel = strings.ToLower(strings.TrimSpace(phrase))
+}
+
+func (b *Bot) isCancel(obj interface{}) bool {
+	m := obj.(chat.InMsg)
+	b.handlerMu.Lock()
+	defer b.handlerMu.Unlock()
+	return len(b.cancel) > 0 && strings.ToLower(strings.TrimSpace(m.Body)) == b.cancel
+}
+
+// DefaultExpiryCheckInterval is how often the bot pops handlers past their
+// deadline unless SetExpiryCheckInterval says otherwise. They're also popped
+// before each message is handled.
+const DefaultExpiryCheckInterval = time.Minute
+
+// SetExpiryCheckInterval changes how often handlers past their deadline are
+// popped. It has no effect once a handler has been given a deadline.
+func (b *Bot) SetExpiryCheckInterval(d time.Duration) {
+	b.handlerMu.Lock()
+	defer b.handlerMu.Unlock()
+	b.expiryInterval = d
+}
+
+// ExpireHandler pops obj after d unless it's popped first, telling its parent
+// it was popped with cmd.TimedOut.
+func (b *Bot) ExpireHandler(obj MessageHandler, d time.Duration) {
+	b.cmdStack.SetTTL(b.wrappedHandler(obj), d)
+	b.expiring.Do(func() {
+		b.handlerMu.Lock()
+		interval := b.expiryInterval
+		b.handlerMu.Unlock()
+		go b.popExpired(interval)
+	})
+}
+
+func (b *Bot) popExpired(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			b.expire()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// expire pops expired handlers between messages so parents aren't told while
+// they're handling one.
+func (b *Bot) expire() {
+	b.dispatchMu.Lock()
+	defer b.dispatchMu.Unlock()
+	defer b.recoverExpiry()
+	b.cmdStack.Expire()
+}
+
+// Stop stops the bot's background work. Its networks are left connected.
+func (b *Bot) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+// ConversationKey identifies the sender of m and where they said it. A user's
+// PMs and what they say in each room are separate conversations.
+func ConversationKey(m chat.InMsg) string {
+	if m.IsPM() {
+		return m.Network + "/pm/" + m.From
+	}
+	return m.Network + "/room/" + *m.RoomID + "/" + m.From
+}
+
 func (b *Bot) PopHandler(obj MessageHandler) {
 	b.cmdStack.Pop(b.wrappedHandler(obj))
 }
 
+// CompleteHandler pops obj, passing value to its parent.
+func (b *Bot) CompleteHandler(obj MessageHandler, value interface{}) {
+	b.PopHandlerWith(obj, cmd.Result{Reason: cmd.Completed, Value: value})
+}
+
+// PopHandlerWith pops obj, passing result to its parent.
+func (b *Bot) PopHandlerWith(obj MessageHandler, result cmd.Result) {
+	b.cmdStack.PopWith(b.wrappedHandler(obj), result)
+}
+
 func (b *Bot) ParentHandler(obj MessageHandler) MessageHandler {
-	wrappedParent := b.cmdStack.Parent(b.wrappedHandler(obj)).(*commandWrapper)
-	if wrappedParent != nil {
+	if wrappedParent, ok := b.cmdStack.Parent(b.wrappedHandler(obj)).(*commandWrapper); ok {
 		return wrappedParent.handler
 	}
 	return nil
 }
 
 func NewBot(n chat.Network) *Bot {
-	b := &Bot{n, cmd.NewStack(), make(map[MessageHandler]*commandWrapper, 0), true}
-	go func() {
-		for m := range n.Messages() {
-			b.HandleMessage(m)
-			duration := time.Since(m.ArrivedAt)
-			fmt.Printf("[Handled in %v]\n", duration)
-		}
-	}()
+	b := &Bot{
+		Network:        n,
+		cmdStack:       cmd.NewStack(),
+		handlerMap:     make(map[MessageHandler]*commandWrapper, 0),
+		networks:       make(map[string]chat.Network, 0),
+		stop:           make(chan struct{}),
+		expiryInterval: DefaultExpiryCheckInterval,
+	}
+	b.cmdStack.KeyConversations(func(obj interface{}) string {
+		return ConversationKey(obj.(chat.InMsg))
+	})
+	b.cmdStack.CancelWhen(b.isCancel, func(obj interface{}) {
+		b.Reply(obj.(chat.InMsg), "Cancelled.")
+	})
+	b.SetCancelPhrase(DefaultCancelPhrase)
+	b.Use(LogMessages(os.Stdout))
+	b.AddRootHandler(&helpHandler{})
+	go b.handleMessages("", n)
 	return b
 }
 
+// AttachNetwork handles messages from another network alongside the bot's
+// own. Messages from it are tagged with name so replies find their way back.
+func (b *Bot) AttachNetwork(name string, n chat.Network) error {
+	if len(name) == 0 {
+		return errors.New("network name must be set to attach network")
+	}
+	b.networksMu.Lock()
+	defer b.networksMu.Unlock()
+	if _, exists := b.networks[name]; exists {
+		return fmt.Errorf("Network %v is already attached", name)
+	}
+	b.networks[name] = n
+	go b.handleMessages(name, n)
+	return nil
+}
+
+// NetworkNamed returns the network attached with name, or the bot's own
+// network when name is empty.
+func (b *Bot) NetworkNamed(name string) chat.Network {
+	if len(name) == 0 {
+		return b.Network
+	}
+	b.networksMu.RLock()
+	defer b.networksMu.RUnlock()
+	return b.networks[name]
+}
+
+// NetworkNames lists the attached networks, not including the bot's own.
+func (b *Bot) NetworkNames() []string {
+	b.networksMu.RLock()
+	defer b.networksMu.RUnlock()
+	names := make([]string, 0, len(b.networks))
+	for name := range b.networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *Bot) handleMessages(name string, n chat.Network) {
+	for m := range n.Messages() {
+		m.Network = name
+		b.HandleMessage(m)
+	}
+}
+
 func (b *Bot) wrappedHandler(obj MessageHandler) cmd.Command {
 	if obj == nil {
 		return nil
 	}
+	// handlers may be pushed from outside the message loop (e.g. schedulers)
+	b.handlerMu.Lock()
+	defer b.handlerMu.Unlock()
 	if wrapper, ok := b.handlerMap[obj]; ok {
 		return wrapper
 	} else {
@@ -75,22 +266,48 @@ type commandWrapper struct {
 }
 
 func (c *commandWrapper) Handle(s *cmd.Stack, obj interface{}) bool {
-	return c.handler.HandleMessage(c.bot, obj.(chat.InMsg))
+	handled := c.handler.HandleMessage(c.bot, obj.(chat.InMsg))
+	if handled {
+		c.bot.handledBy = c.handler
+	}
+	return handled
+}
+
+func (c *commandWrapper) CommandName() string {
+	if r, ok := c.handler.(NamedRoot); ok {
+		return r.HandlerKind()
+	}
+	return ""
+}
+
+func (c *commandWrapper) Popped(s *cmd.Stack, result cmd.Result) {
+	if p, ok := c.handler.(PoppedHandler); ok {
+		p.Popped(c.bot, result)
+	}
 }
 
-func (c *commandWrapper) ChildPopped(s *cmd.Stack, child cmd.Command, id int) {
+func (c *commandWrapper) ChildPopped(s *cmd.Stack, child cmd.Command, id int, result cmd.Result) {
 	if p, ok := c.handler.(PoppedChildHandler); ok {
-		p.ChildPopped(c.bot, child.(*commandWrapper).handler, id)
+		p.ChildPopped(c.bot, child.(*commandWrapper).handler, id, result)
 	}
 }
 
+// HandleMessage passes m to the handlers. A handler panicking doesn't stop
+// the bot handling later messages.
 func (b *Bot) HandleMessage(m chat.InMsg) {
-	roomID := "PM"
-	if m.RoomID != nil {
-		roomID = *m.RoomID
+	// messages from every network, and expiry, are handled one at a time
+	b.dispatchMu.Lock()
+	defer b.dispatchMu.Unlock()
+	defer b.recoverMessage(m)
+	b.handledBy = nil
+	b.chain(HandlerFunc(func(b *Bot, m chat.InMsg) bool {
+		return b.cmdStack.Handle(m)
+	})).HandleMessage(b, m)
+	// pushing and popping save the stack, but a persistent handler may have
+	// changed its state without either
+	if _, ok := b.handledBy.(PersistentHandler); ok {
+		b.saveStack()
 	}
-	fmt.Printf("<%v (%v)> %v\n", m.From, roomID, m.Body)
-	b.cmdStack.Handle(m)
 }
 
 func (b *Bot) ReplyPM(orig chat.InMsg, body string) {
@@ -99,13 +316,36 @@ func (b *Bot) ReplyPM(orig chat.InMsg, body string) {
 }
 
 func (b *Bot) Reply(orig chat.InMsg, body string) {
+	n := b.NetworkNamed(orig.Network)
+	if n == nil {
+		fmt.Printf("Unable to reply on unknown network %v\n", orig.Network)
+		return
+	}
 	if orig.RoomID != nil {
-		fmt.Printf("<%v (%v)> %v\n", b.NickName(), *orig.RoomID, body)
-		b.Send(chat.OutMsg{To: *orig.RoomID, Body: body})
+		fmt.Printf("<%v (%v)> %v\n", n.NickName(), *orig.RoomID, body)
+		n.Send(chat.OutMsg{To: *orig.RoomID, Body: body})
 	} else {
-		fmt.Printf("<%v (PM:%v)> %v\n", b.NickName(), orig.From, body)
-		b.SendPM(chat.OutMsg{To: orig.From, Body: body})
+		fmt.Printf("<%v (PM:%v)> %v\n", n.NickName(), orig.From, body)
+		n.SendPM(chat.OutMsg{To: orig.From, Body: body})
+	}
+}
+
+// SendPMOn sends a private message on the named network.
+func (b *Bot) SendPMOn(network string, m chat.OutMsg) error {
+	n := b.NetworkNamed(network)
+	if n == nil {
+		return fmt.Errorf("Unable to find network with name %v", network)
+	}
+	return n.SendPM(m)
+}
+
+// SendOn sends a message to a room on the named network.
+func (b *Bot) SendOn(network string, m chat.OutMsg) error {
+	n := b.NetworkNamed(network)
+	if n == nil {
+		return fmt.Errorf("Unable to find network with name %v", network)
 	}
+	return n.Send(m)
 }
 
 func panicErr(err error) {
@@ -3,7 +3,9 @@ package bot
 import (
 	"github.com/mackross/go-bot/bottest"
 	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/cmd"
 	"testing"
+	"time"
 )
 
 func TestGreeterCmd(t *testing.T) {
@@ -47,6 +49,7 @@ func (g *greetingHander) HandleMessage(b *Bot, msg chat.InMsg) bool {
 type poppableHandler struct {
 	popOnMsg    bool
 	childPopped int
+	lastResult  cmd.Result
 }
 
 func (p *poppableHandler) HandleMessage(b *Bot, msg chat.InMsg) bool {
@@ -57,8 +60,9 @@ func (p *poppableHandler) HandleMessage(b *Bot, msg chat.InMsg) bool {
 	return false
 }
 
-func (p *poppableHandler) ChildPopped(b *Bot, child MessageHandler, id int) {
+func (p *poppableHandler) ChildPopped(b *Bot, child MessageHandler, id int, result cmd.Result) {
 	p.childPopped++
+	p.lastResult = result
 }
 
 func TestParentPop(t *testing.T) {
@@ -84,6 +88,190 @@ func TestParentPop(t *testing.T) {
 	bot.HandleMessage(chat.InMsg{})
 
 	equals(t, p1.childPopped, 1)
-	equals(t, p2.childPopped, 0)
+	equals(t, p2.childPopped, 1)
+	equals(t, cmd.Result{Reason: cmd.Superseded}, p2.lastResult)
 	equals(t, p3.childPopped, 0)
 }
+
+func TestPushedHandlersOnlySeeTheirConversation(t *testing.T) {
+	mockChat := bottest.NewChat(t)
+	bot := NewBot(mockChat)
+
+	room := "lobby"
+	asked := chat.InMsg{From: "alice", RoomID: &room}
+	p := &poppableHandler{popOnMsg: true}
+	bot.PushHandlerFor(asked, p, nil)
+	child := &poppableHandler{}
+	bot.PushHandler(child, p)
+
+	bot.HandleMessage(chat.InMsg{From: "bob", RoomID: &room})
+	bot.HandleMessage(chat.InMsg{From: "alice"})
+	equals(t, 0, p.childPopped)
+
+	bot.HandleMessage(chat.InMsg{From: "alice", RoomID: &room})
+	equals(t, nil, bot.ParentHandler(child))
+	equals(t, "/room/lobby/alice", ConversationKey(asked))
+	equals(t, "irc/pm/alice", ConversationKey(chat.InMsg{Network: "irc", From: "alice"}))
+}
+
+func TestExpiredHandlersTellTheirParent(t *testing.T) {
+	mockChat := bottest.NewChat(t)
+	bot := NewBot(mockChat)
+	defer bot.Stop()
+
+	p := &poppableHandler{}
+	child := &poppableHandler{}
+	bot.PushHandler(p, nil)
+	bot.PushHandler(child, p)
+	bot.ExpireHandler(child, -time.Second)
+
+	bot.HandleMessage(chat.InMsg{})
+	equals(t, 1, p.childPopped)
+	equals(t, cmd.Result{Reason: cmd.TimedOut}, p.lastResult)
+}
+
+type panickingParent struct {
+	popped chan cmd.Result
+}
+
+func (p *panickingParent) HandleMessage(b *Bot, msg chat.InMsg) bool {
+	return false
+}
+
+func (p *panickingParent) ChildPopped(b *Bot, child MessageHandler, id int, result cmd.Result) {
+	p.popped <- result
+	panic("boom")
+}
+
+func TestExpiryRecoversFromPanickingParents(t *testing.T) {
+	mockChat := bottest.NewChat(t)
+	bot := NewBot(mockChat)
+	defer bot.Stop()
+	bot.SetExpiryCheckInterval(10 * time.Millisecond)
+
+	p := &panickingParent{make(chan cmd.Result, 1)}
+	child := &poppableHandler{}
+	bot.PushHandler(p, nil)
+	bot.PushHandler(child, p)
+	bot.ExpireHandler(child, time.Millisecond)
+	select {
+	case result := <-p.popped:
+		equals(t, cmd.TimedOut, result.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the child to expire")
+	}
+
+	// the bot is still handling messages
+	bot.AddRootHandler(NewGreeter("Towlie", "Howdy Ho!"))
+	mockChat.ExpectPM(chat.OutMsg{To: "12345", Body: "Howdy Ho!"})
+	bot.HandleMessage(chat.InMsg{From: "12345", Body: "Hello Towlie"})
+	mockChat.Check()
+}
+
+func TestCompletedHandlersPassTheirValue(t *testing.T) {
+	mockChat := bottest.NewChat(t)
+	bot := NewBot(mockChat)
+
+	p := &poppableHandler{}
+	child := &poppableHandler{}
+	bot.PushHandler(p, nil)
+	bot.PushHandler(child, p)
+	bot.CompleteHandler(child, "Batman")
+
+	equals(t, cmd.Result{Reason: cmd.Completed, Value: "Batman"}, p.lastResult)
+}
+
+func TestCancelAbandonsTheConversation(t *testing.T) {
+	mockChat := bottest.NewChat(t)
+	bot := NewBot(mockChat)
+	bot.SetCancelPhrase("Never mind")
+
+	p := &poppableHandler{}
+	bot.AddRootHandler(p)
+	child := &poppableHandler{}
+	grandchild := &poppableHandler{popOnMsg: true}
+	bot.PushHandlerFor(chat.InMsg{From: "alice"}, child, p)
+	bot.PushHandler(grandchild, child)
+
+	// someone else saying it doesn't cancel alice's conversation
+	bot.HandleMessage(chat.InMsg{From: "bob", Body: "never mind"})
+	equals(t, 0, p.childPopped)
+
+	mockChat.ExpectPM(chat.OutMsg{To: "alice", Body: "Cancelled."})
+	bot.HandleMessage(chat.InMsg{From: "alice", Body: "never mind"})
+	mockChat.Check()
+	equals(t, 1, p.childPopped)
+	equals(t, cmd.Result{Reason: cmd.Cancelled}, p.lastResult)
+	equals(t, cmd.Result{Reason: cmd.Superseded}, child.lastResult)
+}
+
+func TestRepliesRouteToOriginNetwork(t *testing.T) {
+	hipchat := bottest.NewChat(t)
+	irc := bottest.NewChat(t)
+	bot := NewBot(hipchat)
+	ok(t, bot.AttachNetwork("irc", irc))
+	assert(t, bot.AttachNetwork("irc", irc) != nil, "expected error attaching a network twice")
+	equals(t, []string{"irc"}, bot.NetworkNames())
+
+	bot.AddRootHandler(NewGreeter("Towlie", "Howdy Ho!"))
+
+	irc.ExpectPM(chat.OutMsg{To: "alice", Body: "Howdy Ho!"})
+	hipchat.ExpectPM(chat.OutMsg{To: "12345", Body: "Howdy Ho!"})
+	bot.HandleMessage(chat.InMsg{Network: "irc", From: "alice", Body: "Hello Towlie"})
+	bot.HandleMessage(chat.InMsg{From: "12345", Body: "Hello Towlie"})
+
+	irc.ExpectPM(chat.OutMsg{To: "bob", Body: "psst"})
+	ok(t, bot.SendPMOn("irc", chat.OutMsg{To: "bob", Body: "psst"}))
+	assert(t, bot.SendPMOn("slack", chat.OutMsg{To: "bob", Body: "psst"}) != nil, "expected error sending on unknown network")
+
+	irc.Check()
+	hipchat.Check()
+}
+
+type countingStore struct {
+	saves int
+}
+
+func (s *countingStore) SaveStack(cmds []cmd.SavedCmd) error {
+	s.saves++
+	return nil
+}
+
+func (s *countingStore) LoadStack() ([]cmd.SavedCmd, error) {
+	return nil, nil
+}
+
+type persistentHandler struct{}
+
+func (p *persistentHandler) HandleMessage(b *Bot, msg chat.InMsg) bool {
+	return true
+}
+
+func (p *persistentHandler) HandlerKind() string {
+	return "bot.test"
+}
+
+func (p *persistentHandler) HandlerState() ([]byte, error) {
+	return nil, nil
+}
+
+func TestStackIsOnlySavedWhenItMayHaveChanged(t *testing.T) {
+	mockChat := bottest.NewChat(t)
+	bot := NewBot(mockChat)
+	store := &countingStore{}
+	ok(t, bot.Persist(store))
+	equals(t, 1, store.saves)
+
+	bot.HandleMessage(chat.InMsg{From: "alice", Body: "hi"})
+	equals(t, 1, store.saves)
+
+	bot.PushHandler(&poppableHandler{}, nil)
+	equals(t, 2, store.saves)
+	bot.HandleMessage(chat.InMsg{From: "alice", Body: "hi"})
+	equals(t, 2, store.saves)
+
+	bot.PushHandler(&persistentHandler{}, nil)
+	equals(t, 3, store.saves)
+	bot.HandleMessage(chat.InMsg{From: "alice", Body: "hi"})
+	equals(t, 4, store.saves)
+}
@@ -40,6 +40,10 @@ func (c *Chat) SetStatus(s string) error {
 	return nil
 }
 
+func (c *Chat) OnConnect() <-chan bool {
+	return make(chan bool, 0)
+}
+
 func (c *Chat) NickName() string {
 	return "botty"
 }
@@ -6,6 +6,7 @@ import (
 
 type InMsg struct {
 	ID        string
+	Network   string  // name the network was attached with, empty for the bot's own
 	RoomID    *string // when nil it is a private message
 	From      string
 	Body      string
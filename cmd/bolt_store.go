@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+func NewBoltStore(b *bolt.DB) *BoltStore {
+	return &BoltStore{b}
+}
+
+// BoltStore keeps the latest snapshot of the stack under a single key, so
+// saving replaces whatever was saved before.
+type BoltStore struct {
+	*bolt.DB
+}
+
+var (
+	bucket = []byte("stack")
+	key    = []byte("pushed")
+)
+
+func (s *BoltStore) SaveStack(cmds []SavedCmd) error {
+	return s.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		sc, err := json.Marshal(cmds)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, sc)
+	})
+}
+
+func (s *BoltStore) LoadStack() ([]SavedCmd, error) {
+	cmds := make([]SavedCmd, 0)
+	err := s.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucket)
+		if bucket == nil {
+			return nil
+		}
+		sc := bucket.Get(key)
+		if sc == nil {
+			return nil
+		}
+		return json.Unmarshal(sc, &cmds)
+	})
+	return cmds, err
+}
@@ -0,0 +1,44 @@
+package cmd
+
+// CancelWhen makes Handle cancel the conversation of any message isCancel
+// matches instead of offering it to commands, provided the conversation has
+// commands pushed for it. cancelled, which may be nil, is then called with the
+// message.
+func (s *Stack) CancelWhen(isCancel func(obj interface{}) bool, cancelled func(obj interface{})) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.isCancel = isCancel
+	s.cancelled = cancelled
+}
+
+// Cancel pops every command pushed for the conversation key, parents and
+// children alike. Parents outside the conversation, such as the root that
+// started it, are told their child was cancelled, and those inside it that
+// their children were superseded. It returns how many commands were popped.
+func (s *Stack) Cancel(key string) int {
+	s.Lock()
+	ids := s.conversations[key]
+	inConversation := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		inConversation[id] = true
+	}
+	heads := make([]int, 0)
+	for i := len(ids) - 1; i >= 0; i-- {
+		if parentID, ok := s.parents[ids[i]]; !ok || !inConversation[parentID] {
+			heads = append(heads, ids[i])
+		}
+	}
+	popped := make([]poppedCmd, 0, len(ids))
+	for _, id := range heads {
+		// a head may have gone with a command pushed for another conversation
+		if c, ok := s.commands[id]; ok {
+			popped = append(popped, s.pop(c, Result{Reason: Cancelled})...)
+		}
+	}
+	n := len(ids) - len(s.conversations[key])
+	s.Unlock()
+
+	s.notify(popped)
+	return n
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"testing"
+)
+
+type conversationMsg struct {
+	key  string
+	body string
+}
+
+func conversationStack() *Stack {
+	s := NewStack()
+	s.KeyConversations(func(obj interface{}) string { return obj.(conversationMsg).key })
+	return s
+}
+
+func TestCancelPopsTheWholeConversation(t *testing.T) {
+	s := conversationStack()
+	cancelled := make([]interface{}, 0)
+	s.CancelWhen(func(obj interface{}) bool {
+		return obj.(conversationMsg).body == "cancel"
+	}, func(obj interface{}) {
+		cancelled = append(cancelled, obj)
+	})
+
+	root := &ResultCmd{}
+	s.AddRoot(root)
+
+	// alice has two trees, one started by the root, and bob has another
+	wizard := &ResultCmd{}
+	step := &ResultCmd{}
+	prompt := &HandlerCmd{HandleNextMessage: true}
+	s.PushCmdFor("alice", wizard, root)
+	s.PushCmd(step, wizard)
+	s.PushCmd(prompt, step)
+	question := &HandlerCmd{}
+	s.PushCmdFor("alice", question, nil)
+	bob := &HandlerCmd{}
+	s.PushCmdFor("bob", bob, root)
+	everyone := &HandlerCmd{}
+	s.PushCmd(everyone, nil)
+
+	s.Handle(conversationMsg{"alice", "cancel"})
+	equals(t, 0, prompt.CouldHandle)
+	equals(t, 0, len(s.Conversation("alice")))
+	equals(t, []Command{bob, everyone}, s.Current())
+	equals(t, []interface{}{conversationMsg{"alice", "cancel"}}, cancelled)
+
+	// the root outside the conversation hears it was cancelled and the popped
+	// parents that their children went with them
+	equals(t, []Result{{Reason: Cancelled}}, root.results)
+	equals(t, []Result{{Reason: Superseded}}, wizard.results)
+	equals(t, []Result{{Reason: Superseded}}, step.results)
+
+	// with nothing left to cancel the message is handled as usual
+	s.Handle(conversationMsg{"alice", "cancel"})
+	equals(t, 1, len(cancelled))
+	equals(t, 1, everyone.CouldHandle)
+	equals(t, 0, bob.CouldHandle)
+}
+
+func TestCancelKeepsParentsOutsideTheConversation(t *testing.T) {
+	s := conversationStack()
+	s.CancelWhen(func(obj interface{}) bool {
+		return obj.(conversationMsg).body == "stop"
+	}, nil)
+
+	sequence := &ResultCmd{}
+	s.PushCmd(sequence, nil)
+	first := &ResultCmd{}
+	s.PushCmdFor("alice", first, sequence)
+	s.PushCmd(&HandlerCmd{}, first)
+	s.PushCmd(&HandlerCmd{}, first)
+	second := &HandlerCmd{}
+	s.PushCmdFor("alice", second, sequence)
+
+	equals(t, 4, s.Cancel("alice"))
+	equals(t, []Command{sequence}, s.Current())
+	equals(t, []Result{{Reason: Cancelled}, {Reason: Cancelled}}, sequence.results)
+	equals(t, 0, s.Cancel("alice"))
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Command interface {
@@ -9,29 +12,98 @@ type Command interface {
 }
 
 type CommandWithChildren interface {
-	ChildPopped(s *Stack, cmd Command, id int)
+	ChildPopped(s *Stack, cmd Command, id int, result Result)
 }
 
+// PoppedCommand is told whenever it's popped, before its parent is, so it
+// can clean up whether or not it has a parent.
+type PoppedCommand interface {
+	Popped(s *Stack, result Result)
+}
+
+// PopReason is why a child was popped.
+type PopReason int
+
+const (
+	Completed  PopReason = iota // the child finished, maybe with a value
+	Cancelled                   // the user abandoned the conversation
+	TimedOut                    // the child's deadline passed
+	Superseded                  // the child was popped along with its parent
+)
+
+func (r PopReason) String() string {
+	switch r {
+	case Completed:
+		return "completed"
+	case Cancelled:
+		return "cancelled"
+	case TimedOut:
+		return "timed out"
+	case Superseded:
+		return "superseded"
+	}
+	return fmt.Sprintf("PopReason(%d)", int(r))
+}
+
+// Result is passed to a parent when its child pops. Value is whatever the
+// child finished with, such as the answer to a question.
+type Result struct {
+	Reason PopReason
+	Value  interface{}
+}
+
+// ConversationKey returns the conversation a message belongs to, or an empty
+// key when it's not part of one.
+type ConversationKey func(obj interface{}) string
+
+// Stack holds the root commands and the commands pushed for each
+// conversation. Commands pushed without a conversation see every message.
 type Stack struct {
 	sync.RWMutex
-	commands map[int]Command
-	rootIDs  []int
-	stackIDs []int
-	parents  map[int]int
-	lastID   int
+	commands      map[int]Command
+	ids           map[Command]int
+	rootIDs       []int
+	stackIDs      []int
+	conversations map[string][]int
+	keys          map[int]string
+	parents       map[int]int
+	deadlines     map[int]time.Time
+	lastID        int
+	onChange      func(s *Stack)
+	key           ConversationKey
+	isCancel      func(obj interface{}) bool
+	cancelled     func(obj interface{})
 }
 
 func NewStack() *Stack {
 	return &Stack{
 		sync.RWMutex{},
 		make(map[int]Command, 0),
+		make(map[Command]int, 0),
 		make([]int, 0),
 		make([]int, 0),
+		make(map[string][]int, 0),
+		make(map[int]string, 0),
 		make(map[int]int),
+		make(map[int]time.Time, 0),
 		0,
+		nil,
+		nil,
+		nil,
+		nil,
 	}
 }
 
+// KeyConversations sets how messages are matched to the conversations
+// commands were pushed for. Until it's set only commands pushed without a
+// conversation see messages.
+func (s *Stack) KeyConversations(key ConversationKey) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.key = key
+}
+
 func (s *Stack) Current() []Command {
 	s.RLock()
 	defer s.RUnlock()
@@ -53,18 +125,62 @@ func (s *Stack) AddRoot(c Command) {
 	s.addRoot(c)
 }
 
+// PushCmd pushes c into the conversation of parent, or for every conversation
+// when parent isn't a pushed command.
 func (s *Stack) PushCmd(c Command, parent Command) int {
 	s.Lock()
-	defer s.Unlock()
+	id := s.pushCmd(c, parent, s.keys[s.ids[parent]])
+	s.Unlock()
+
+	s.changed()
+	return id
+}
+
+// PushCmdFor pushes c so it only sees messages from the conversation key.
+func (s *Stack) PushCmdFor(key string, c Command, parent Command) int {
+	s.Lock()
+	id := s.pushCmd(c, parent, key)
+	s.Unlock()
 
-	return s.pushCmd(c, parent)
+	s.changed()
+	return id
 }
 
+// Pop removes cmd and its children, telling its parent it completed.
 func (s *Stack) Pop(cmd Command) {
+	s.PopWith(cmd, Result{Reason: Completed})
+}
+
+// PopWith removes cmd and its children, passing result to its parent. cmd is
+// told each of its children was Superseded, deepest first.
+func (s *Stack) PopWith(cmd Command, result Result) {
 	s.Lock()
-	defer s.Unlock()
+	popped := s.pop(cmd, result)
+	s.Unlock()
+
+	s.notify(popped)
+}
+
+// poppedCmd is a popped command whose parent is yet to be told.
+type poppedCmd struct {
+	parent Command
+	cmd    Command
+	id     int
+	result Result
+}
 
-	s.pop(cmd, true)
+// notify is called outside the lock so parents are free to push their next
+// child.
+func (s *Stack) notify(popped []poppedCmd) {
+	for _, p := range popped {
+		if c, ok := p.cmd.(PoppedCommand); ok {
+			c.Popped(s, p.result)
+		}
+		if parent, ok := p.parent.(CommandWithChildren); ok {
+			parent.ChildPopped(s, p.cmd, p.id, p.result)
+		}
+	}
+	s.changed()
 }
 
 func (s *Stack) Parent(cmd Command) Command {
@@ -74,74 +190,128 @@ func (s *Stack) Parent(cmd Command) Command {
 	return s.parent(cmd)
 }
 
-func (s *Stack) Handle(obj interface{}) {
+// Conversation returns the commands pushed for the conversation key, in the
+// order they were pushed.
+func (s *Stack) Conversation(key string) []Command {
 	s.RLock()
-	cmds := s.Current()
-	roots := s.Roots()
-	s.RUnlock()
+	defer s.RUnlock()
+
+	return s.lookup(s.conversations[key])
+}
+
+// Handle pops expired commands then offers obj to the commands pushed for its
+// conversation, then those pushed for every conversation, most recent first.
+// If none of them handle it every root gets a chance. Messages asking to
+// cancel a conversation are handled by the stack, see CancelWhen. It reports
+// whether obj was handled.
+func (s *Stack) Handle(obj interface{}) bool {
+	s.Expire()
 
-	for i, j := 0, len(cmds)-1; i < j; i, j = i+1, j-1 {
-		cmds[i], cmds[j] = cmds[j], cmds[i]
+	s.RLock()
+	key := ""
+	if s.key != nil {
+		key = s.key(obj)
+	}
+	if len(key) > 0 && len(s.conversations[key]) > 0 && s.isCancel != nil && s.isCancel(obj) {
+		cancelled := s.cancelled
+		s.RUnlock()
+
+		s.Cancel(key)
+		if cancelled != nil {
+			cancelled(obj)
+		}
+		return true
 	}
+	cmds := make([]Command, 0)
+	if len(key) > 0 {
+		cmds = append(cmds, s.latestFirst(s.conversations[key])...)
+	}
+	cmds = append(cmds, s.latestFirst(s.conversations[""])...)
+	roots := s.roots()
+	s.RUnlock()
+
 	for _, cmd := range cmds {
 		if cmd.Handle(s, obj) {
-			return
+			return true
 		}
 	}
 
+	handled := false
 	for _, cmd := range roots {
-		cmd.Handle(s, obj)
+		if cmd.Handle(s, obj) {
+			handled = true
+		}
 	}
-
+	return handled
 }
 
 func (s *Stack) current() []Command {
-	cmds := make([]Command, 0, len(s.stackIDs))
-	for _, id := range s.stackIDs {
-		cmd, _ := s.commands[id]
-		cmds = append(cmds, cmd)
+	return s.lookup(s.stackIDs)
+}
+
+func (s *Stack) roots() []Command {
+	return s.lookup(s.rootIDs)
+}
+
+func (s *Stack) lookup(ids []int) []Command {
+	cmds := make([]Command, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, s.commands[id])
 	}
 	return cmds
 }
 
-func (s *Stack) roots() []Command {
-	cmds := make([]Command, 0, len(s.rootIDs))
-	for _, id := range s.rootIDs {
-		cmd, _ := s.commands[id]
-		cmds = append(cmds, cmd)
+func (s *Stack) latestFirst(ids []int) []Command {
+	cmds := make([]Command, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		cmds = append(cmds, s.commands[ids[i]])
 	}
 	return cmds
 }
 
-func (s *Stack) pop(cmd Command, notify bool) {
+func (s *Stack) pop(cmd Command, result Result) []poppedCmd {
 	id, ok := 0, false
 	if id, ok = s.findCmdID(cmd); !ok {
 		panic("cannot find id")
 	}
 
-	s.popChildren(id)
-
-	parent := s.parent(cmd)
+	popped := s.popChildren(id)
+	popped = append(popped, poppedCmd{s.parent(cmd), cmd, id, result})
 
 	s.removeID(id)
 
-	if p, ok := parent.(CommandWithChildren); notify && ok {
-		p.ChildPopped(s, cmd, id)
-	}
+	return popped
 }
 
-func (s *Stack) popChildren(id int) {
-	for i, p := range s.parents {
+func (s *Stack) popChildren(id int) []poppedCmd {
+	children := make([]int, 0)
+	for child, p := range s.parents {
 		if p == id {
-			s.pop(s.commands[i], false)
+			children = append(children, child)
 		}
 	}
+	sort.Ints(children)
+	popped := make([]poppedCmd, 0)
+	for _, child := range children {
+		popped = append(popped, s.pop(s.commands[child], Result{Reason: Superseded})...)
+	}
+	return popped
 }
 
 func (s *Stack) removeID(id int) {
 	s.stackIDs = remove(s.stackIDs, id)
 	s.rootIDs = remove(s.rootIDs, id)
+	if key, ok := s.keys[id]; ok {
+		if ids := remove(s.conversations[key], id); len(ids) > 0 {
+			s.conversations[key] = ids
+		} else {
+			delete(s.conversations, key)
+		}
+	}
+	delete(s.keys, id)
+	delete(s.deadlines, id)
 	delete(s.parents, id)
+	delete(s.ids, s.commands[id])
 	delete(s.commands, id)
 }
 
@@ -150,9 +320,11 @@ func (s *Stack) addRoot(c Command) {
 	s.rootIDs = append(s.rootIDs, id)
 }
 
-func (s *Stack) pushCmd(c Command, parent Command) int {
+func (s *Stack) pushCmd(c Command, parent Command, key string) int {
 	id := s.insertCmd(c)
 	s.stackIDs = append(s.stackIDs, id)
+	s.conversations[key] = append(s.conversations[key], id)
+	s.keys[id] = key
 	if parentID, ok := s.findCmdID(parent); ok {
 		s.parents[id] = parentID
 	}
@@ -162,6 +334,7 @@ func (s *Stack) pushCmd(c Command, parent Command) int {
 func (s *Stack) insertCmd(c Command) int {
 	id := s.newID()
 	s.commands[id] = c
+	s.ids[c] = id
 	return id
 }
 
@@ -178,19 +351,18 @@ func (s *Stack) newID() int {
 }
 
 func (s *Stack) findCmdID(cmd Command) (int, bool) {
-	for id, c := range s.commands {
-		if c == cmd {
-			return id, true
-		}
+	if cmd == nil {
+		return 0, false
 	}
-	return 0, false
+	id, ok := s.ids[cmd]
+	return id, ok
 }
 
 func remove(orig []int, value int) []int {
 	ret := make([]int, 0, len(orig))
-	for i := len(orig) - 1; i >= 0; i-- {
-		if orig[i] != value {
-			ret = append(ret, orig[i])
+	for _, v := range orig {
+		if v != value {
+			ret = append(ret, v)
 		}
 	}
 	return ret
@@ -13,7 +13,7 @@ func (h *BasicCmd) Handle(s *Stack, obj interface{}) bool {
 	return false
 }
 
-func (c *BasicCmd) ChildPopped(s *Stack, cmd Command, id int) {
+func (c *BasicCmd) ChildPopped(s *Stack, cmd Command, id int, result Result) {
 	c.lastPopped = id
 	c.popped++
 }
@@ -59,8 +59,8 @@ func TestChildCmds(t *testing.T) {
 	equals(t, 2, rootCmd.popped)
 	equals(t, rootCmd.lastPopped, cmd2ID)
 
-	// cmd2 shouldnt be notified of cmd3 popping
-	equals(t, childCmd2.popped, 0)
+	// cmd2 was told cmd3 went with it
+	equals(t, childCmd2.popped, 1)
 
 }
 
@@ -97,7 +97,7 @@ func TestHandler(t *testing.T) {
 	s.PushCmd(cmd2, cmd1)
 	s.PushCmd(cmd3, nil)
 
-	s.Handle("test")
+	equals(t, false, s.Handle("test"))
 
 	equals(t, cmd1.CouldHandle, 1)
 	equals(t, cmd4.CouldHandle, 1)
@@ -105,7 +105,7 @@ func TestHandler(t *testing.T) {
 	equals(t, cmd3.CouldHandle, 1)
 
 	cmd3.HandleNextMessage = true
-	s.Handle("test")
+	equals(t, true, s.Handle("test"))
 
 	equals(t, cmd1.CouldHandle, 1)
 	equals(t, cmd4.CouldHandle, 1)
@@ -117,7 +117,7 @@ func TestHandler(t *testing.T) {
 
 	cmd1.HandleNextMessage = true
 	cmd3.HandleNextMessage = false
-	s.Handle("test")
+	equals(t, true, s.Handle("test"))
 	equals(t, cmd1.CouldHandle, 2)
 	equals(t, cmd4.CouldHandle, 2)
 	equals(t, cmd2.CouldHandle, 2)
@@ -127,7 +127,7 @@ func TestHandler(t *testing.T) {
 	equals(t, cmd3.Handled, 1)
 
 	cmd1.PopSelfAfterNextMessage = true
-	s.Handle("test")
+	equals(t, true, s.Handle("test"))
 
 	equals(t, cmd1.CouldHandle, 3)
 	equals(t, cmd4.CouldHandle, 3)
@@ -137,7 +137,7 @@ func TestHandler(t *testing.T) {
 	equals(t, cmd2.Handled, 0)
 	equals(t, cmd3.Handled, 1)
 
-	s.Handle("test")
+	equals(t, false, s.Handle("test"))
 
 	equals(t, cmd1.CouldHandle, 3)
 	equals(t, cmd4.CouldHandle, 4)
@@ -147,3 +147,152 @@ func TestHandler(t *testing.T) {
 	equals(t, cmd2.Handled, 0)
 	equals(t, cmd3.Handled, 1)
 }
+
+type SequenceCmd struct {
+	children []Command
+	done     bool
+}
+
+func (c *SequenceCmd) Handle(s *Stack, obj interface{}) bool {
+	return false
+}
+
+func (c *SequenceCmd) ChildPopped(s *Stack, cmd Command, id int, result Result) {
+	if len(c.children) == 0 {
+		c.done = true
+		return
+	}
+	s.PushCmd(c.children[0], c)
+	c.children = c.children[1:]
+}
+
+func TestPushFromChildPopped(t *testing.T) {
+	s := NewStack()
+
+	first := &HandlerCmd{HandleNextMessage: true, PopSelfAfterNextMessage: true}
+	second := &HandlerCmd{HandleNextMessage: true, PopSelfAfterNextMessage: true}
+	seq := &SequenceCmd{children: []Command{second}}
+
+	s.PushCmd(seq, nil)
+	s.PushCmd(first, seq)
+
+	s.Handle("first")
+	equals(t, first.Handled, 1)
+	equals(t, s.Current(), []Command{seq, second})
+
+	s.Handle("second")
+	equals(t, second.Handled, 1)
+	equals(t, seq.done, true)
+	equals(t, s.Current(), []Command{seq})
+}
+
+func TestCmdsOnlySeeTheirConversation(t *testing.T) {
+	s := NewStack()
+	s.KeyConversations(func(obj interface{}) string { return obj.(string) })
+
+	root := &HandlerCmd{}
+	alice := &HandlerCmd{HandleNextMessage: true}
+	aliceChild := &HandlerCmd{}
+	bob := &HandlerCmd{HandleNextMessage: true}
+	everyone := &HandlerCmd{}
+
+	s.AddRoot(root)
+	s.PushCmdFor("alice", alice, nil)
+	s.PushCmd(aliceChild, alice)
+	s.PushCmdFor("bob", bob, nil)
+	s.PushCmd(everyone, nil)
+
+	equals(t, []Command{alice, aliceChild}, s.Conversation("alice"))
+	equals(t, []Command{bob}, s.Conversation("bob"))
+
+	s.Handle("alice")
+	equals(t, 1, aliceChild.CouldHandle)
+	equals(t, 1, alice.Handled)
+	equals(t, 0, bob.CouldHandle)
+	equals(t, 0, everyone.CouldHandle)
+
+	s.Handle("carol")
+	equals(t, 1, everyone.CouldHandle)
+	equals(t, 1, root.CouldHandle)
+	equals(t, 0, bob.CouldHandle)
+
+	s.Pop(alice)
+	equals(t, 0, len(s.Conversation("alice")))
+	equals(t, []Command{bob, everyone}, s.Current())
+}
+
+func TestPopKeepsPushOrder(t *testing.T) {
+	s := NewStack()
+	cmds := []Command{&BasicCmd{}, &BasicCmd{}, &BasicCmd{}, &BasicCmd{}}
+	for _, c := range cmds {
+		s.PushCmd(c, nil)
+	}
+	s.Pop(cmds[1])
+	equals(t, []Command{cmds[0], cmds[2], cmds[3]}, s.Current())
+}
+
+func TestParentsAreGivenTheChildsResult(t *testing.T) {
+	s := NewStack()
+	parent := &ResultCmd{}
+	answered := &BasicCmd{}
+	replaced := &BasicCmd{}
+	s.PushCmd(parent, nil)
+	s.PushCmd(answered, parent)
+	s.PushCmd(replaced, parent)
+
+	s.PopWith(answered, Result{Reason: Completed, Value: 42})
+	s.PopWith(replaced, Result{Reason: Cancelled})
+	equals(t, []Result{{Completed, 42}, {Cancelled, nil}}, parent.results)
+	equals(t, "superseded", Superseded.String())
+}
+
+type ResultCmd struct {
+	BasicCmd
+	results []Result
+}
+
+func (c *ResultCmd) ChildPopped(s *Stack, cmd Command, id int, result Result) {
+	c.results = append(c.results, result)
+}
+
+func TestParentPoppedWithLiveChildrenIsToldTheyWereSuperseded(t *testing.T) {
+	s := NewStack()
+	root := &ResultCmd{}
+	parent := &ResultCmd{}
+	child := &ResultCmd{}
+	grandchild := &BasicCmd{}
+	s.AddRoot(root)
+	s.PushCmd(parent, root)
+	s.PushCmd(child, parent)
+	s.PushCmd(grandchild, child)
+
+	s.Pop(parent)
+	equals(t, []Result{{Reason: Superseded}}, child.results)
+	equals(t, []Result{{Reason: Superseded}}, parent.results)
+	equals(t, []Result{{Reason: Completed}}, root.results)
+	equals(t, 0, len(s.Current()))
+}
+
+type PoppedCmd struct {
+	BasicCmd
+	popped []Result
+}
+
+func (c *PoppedCmd) Popped(s *Stack, result Result) {
+	c.popped = append(c.popped, result)
+}
+
+func TestCmdsAreToldWhenTheyArePopped(t *testing.T) {
+	s := NewStack()
+	orphan := &PoppedCmd{}
+	parent := &BasicCmd{}
+	child := &PoppedCmd{}
+	s.PushCmd(orphan, nil)
+	s.PushCmd(parent, nil)
+	s.PushCmd(child, parent)
+
+	s.PopWith(orphan, Result{Reason: Cancelled})
+	equals(t, []Result{{Reason: Cancelled}}, orphan.popped)
+	s.Pop(parent)
+	equals(t, []Result{{Reason: Superseded}}, child.popped)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"sort"
+	"time"
+)
+
+var now = time.Now
+
+// SetDeadline pops c with TimedOut if it's still on the stack at deadline. A
+// zero deadline removes it.
+func (s *Stack) SetDeadline(c Command, deadline time.Time) {
+	s.Lock()
+	id, ok := s.findCmdID(c)
+	if ok && deadline.IsZero() {
+		delete(s.deadlines, id)
+	} else if ok {
+		s.deadlines[id] = deadline
+	}
+	s.Unlock()
+
+	if ok {
+		s.changed()
+	}
+}
+
+// SetTTL pops c with TimedOut if it's still on the stack after ttl.
+func (s *Stack) SetTTL(c Command, ttl time.Duration) {
+	s.SetDeadline(c, now().Add(ttl))
+}
+
+// Deadline returns when c times out, or a zero time when it doesn't.
+func (s *Stack) Deadline(c Command) time.Time {
+	s.RLock()
+	defer s.RUnlock()
+
+	id, _ := s.findCmdID(c)
+	return s.deadlines[id]
+}
+
+// Expire pops the commands whose deadline has passed.
+func (s *Stack) Expire() int {
+	return s.PopExpired(now())
+}
+
+// PopExpired pops the commands whose deadline is before t, oldest first,
+// telling their parents they timed out. It returns how many were popped.
+func (s *Stack) PopExpired(t time.Time) int {
+	s.RLock()
+	expired := make([]int, 0)
+	for id, deadline := range s.deadlines {
+		if deadline.Before(t) {
+			expired = append(expired, id)
+		}
+	}
+	s.RUnlock()
+
+	sort.Ints(expired)
+	n := 0
+	for _, id := range expired {
+		s.Lock()
+		c, ok := s.commands[id]
+		var popped []poppedCmd
+		// popping an earlier command may have taken its children with it
+		if ok {
+			popped = s.pop(c, Result{Reason: TimedOut})
+		}
+		s.Unlock()
+		if ok {
+			s.notify(popped)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+type ReasonCmd struct {
+	BasicCmd
+	reasons []PopReason
+}
+
+func (c *ReasonCmd) ChildPopped(s *Stack, cmd Command, id int, result Result) {
+	c.reasons = append(c.reasons, result.Reason)
+}
+
+func TestExpiredCmdsArePoppedAsTimedOut(t *testing.T) {
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return jan1st2015 }
+	defer func() { now = time.Now }()
+
+	s := NewStack()
+	parent := &ReasonCmd{}
+	child := &HandlerCmd{}
+	grandchild := &HandlerCmd{}
+	finished := &HandlerCmd{}
+	s.PushCmd(parent, nil)
+	s.PushCmd(child, parent)
+	s.PushCmd(grandchild, child)
+	s.PushCmd(finished, parent)
+	s.SetDeadline(child, jan1st2015.Add(time.Minute))
+	s.SetTTL(grandchild, time.Minute)
+	equals(t, jan1st2015.Add(time.Minute), s.Deadline(child))
+
+	equals(t, 0, s.PopExpired(jan1st2015))
+	s.Pop(finished)
+
+	// the grandchild goes with the child so the parent hears once
+	equals(t, 1, s.PopExpired(jan1st2015.Add(time.Hour)))
+	equals(t, []PopReason{Completed, TimedOut}, parent.reasons)
+	equals(t, []Command{parent}, s.Current())
+
+	s.SetDeadline(parent, jan1st2015.Add(-time.Second))
+	s.Handle("too late")
+	equals(t, 0, len(s.Current()))
+}
+
+func TestZeroDeadlineNeverExpires(t *testing.T) {
+	s := NewStack()
+	c := &HandlerCmd{}
+	s.PushCmd(c, nil)
+	s.SetDeadline(c, time.Now().Add(-time.Second))
+	s.SetDeadline(c, time.Time{})
+	equals(t, 0, s.PopExpired(time.Now()))
+	equals(t, "timed out", TimedOut.String())
+}
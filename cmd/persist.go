@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// SavedCmd is a pushed command and its place in the stack. Parents are
+// recorded by saved ID, or for roots by name, see NamedCommand.
+type SavedCmd struct {
+	ID           int
+	Kind         string
+	State        []byte
+	Conversation string
+	Deadline     time.Time
+	Parent       int    // ID of a saved parent, 0 when there isn't one
+	Root         string // name of a root parent, empty when there isn't one
+}
+
+// NamedCommand is a root that commands pushed under it can be restored to.
+// Its name must stay the same between restarts and be unique among the roots.
+type NamedCommand interface {
+	Command
+	CommandName() string
+}
+
+func commandName(c Command) string {
+	if n, ok := c.(NamedCommand); ok {
+		return n.CommandName()
+	}
+	return ""
+}
+
+// Encoder returns the kind and state of c to save, or an empty kind when c
+// can't be saved.
+type Encoder func(c Command) (kind string, state []byte, err error)
+
+// Decoder recreates a command saved with an Encoder.
+type Decoder func(kind string, state []byte) (Command, error)
+
+// Store keeps the pushed commands of a stack between restarts.
+type Store interface {
+	SaveStack(cmds []SavedCmd) error
+	LoadStack() ([]SavedCmd, error)
+}
+
+// OnChange calls f after commands are pushed or popped.
+func (s *Stack) OnChange(f func(s *Stack)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onChange = f
+}
+
+func (s *Stack) changed() {
+	s.RLock()
+	f := s.onChange
+	s.RUnlock()
+
+	if f != nil {
+		f(s)
+	}
+}
+
+// Save encodes the pushed commands in the order they were pushed. Commands
+// that can't be saved, or whose root parent has no name, are left out along
+// with their children.
+func (s *Stack) Save(enc Encoder) ([]SavedCmd, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	saved := make([]SavedCmd, 0, len(s.stackIDs))
+	savedIDs := make(map[int]bool, len(s.stackIDs))
+	for _, id := range s.stackIDs {
+		sc := SavedCmd{ID: id, Conversation: s.keys[id], Deadline: s.deadlines[id]}
+		if parentID, ok := s.parents[id]; ok {
+			if indexOf(s.rootIDs, parentID) >= 0 {
+				if sc.Root = commandName(s.commands[parentID]); len(sc.Root) == 0 {
+					continue
+				}
+			} else if savedIDs[parentID] {
+				sc.Parent = parentID
+			} else {
+				continue
+			}
+		}
+		kind, state, err := enc(s.commands[id])
+		if err != nil {
+			return nil, err
+		}
+		if len(kind) == 0 {
+			continue
+		}
+		sc.Kind, sc.State = kind, state
+		saved = append(saved, sc)
+		savedIDs[id] = true
+	}
+	return saved, nil
+}
+
+// Restore pushes saved commands back on to the stack, after its roots have
+// been added in any order. Commands that fail to decode are skipped along with their
+// children and reported once everything else is restored.
+func (s *Stack) Restore(saved []SavedCmd, dec Decoder) error {
+	s.Lock()
+	restored := make(map[int]Command, len(saved))
+	failed := 0
+	var firstErr error
+	for _, sc := range saved {
+		var parent Command
+		var err error
+		switch {
+		case len(sc.Root) > 0:
+			if parent = s.namedRoot(sc.Root); parent == nil {
+				err = fmt.Errorf("%v has no root %v to return to", sc.Kind, sc.Root)
+			}
+		case sc.Parent != 0:
+			if parent = restored[sc.Parent]; parent == nil {
+				err = fmt.Errorf("%v lost its parent", sc.Kind)
+			}
+		}
+		var c Command
+		if err == nil {
+			c, err = dec(sc.Kind, sc.State)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		newID := s.pushCmd(c, parent, sc.Conversation)
+		if !sc.Deadline.IsZero() {
+			s.deadlines[newID] = sc.Deadline
+		}
+		restored[sc.ID] = c
+	}
+	s.Unlock()
+
+	if failed > 0 {
+		return fmt.Errorf("unable to restore %v commands: %v", failed, firstErr)
+	}
+	return nil
+}
+
+func (s *Stack) namedRoot(name string) Command {
+	for _, id := range s.rootIDs {
+		if commandName(s.commands[id]) == name {
+			return s.commands[id]
+		}
+	}
+	return nil
+}
+
+func indexOf(ids []int, id int) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
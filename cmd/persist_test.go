@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+type SavedStateCmd struct {
+	BasicCmd
+	state string
+}
+
+func encodeSavedStateCmd(c Command) (string, []byte, error) {
+	s, ok := c.(*SavedStateCmd)
+	if !ok {
+		return "", nil, nil
+	}
+	return "saved", []byte(s.state), nil
+}
+
+func decodeSavedStateCmd(kind string, state []byte) (Command, error) {
+	if kind != "saved" {
+		return nil, errors.New("unknown kind " + kind)
+	}
+	return &SavedStateCmd{state: string(state)}, nil
+}
+
+type NamedCmd struct {
+	BasicCmd
+	name string
+}
+
+func (c *NamedCmd) CommandName() string {
+	return c.name
+}
+
+func TestStackSavesAndRestoresPushedCmds(t *testing.T) {
+	s := NewStack()
+	root := &NamedCmd{name: "root"}
+	unnamed := &BasicCmd{}
+	s.AddRoot(&NamedCmd{name: "other"})
+	s.AddRoot(root)
+	s.AddRoot(unnamed)
+
+	parent := &SavedStateCmd{state: "parent"}
+	s.PushCmd(parent, root)
+	s.PushCmd(&SavedStateCmd{state: "child"}, parent)
+	unsaved := &BasicCmd{}
+	s.PushCmd(unsaved, nil)
+	s.PushCmd(&SavedStateCmd{state: "orphan"}, unsaved)
+	s.PushCmd(&SavedStateCmd{state: "alone"}, nil)
+	s.PushCmd(&SavedStateCmd{state: "unnamed root"}, unnamed)
+
+	saved, err := s.Save(encodeSavedStateCmd)
+	ok(t, err)
+	equals(t, 3, len(saved))
+
+	// roots are matched by name so they can be added in another order
+	restored := NewStack()
+	restoredRoot := &NamedCmd{name: "root"}
+	restored.AddRoot(restoredRoot)
+	restored.AddRoot(&NamedCmd{name: "other"})
+	ok(t, restored.Restore(saved, decodeSavedStateCmd))
+
+	current := restored.Current()
+	equals(t, 3, len(current))
+	states := make([]string, 0)
+	for _, c := range current {
+		states = append(states, c.(*SavedStateCmd).state)
+	}
+	equals(t, []string{"parent", "child", "alone"}, states)
+	equals(t, restoredRoot, restored.Parent(current[0]))
+	equals(t, current[0], restored.Parent(current[1]))
+	equals(t, nil, restored.Parent(current[2]))
+
+	// the root is still told when a restored child finishes
+	restored.Pop(current[0])
+	equals(t, 1, restoredRoot.popped)
+	equals(t, 1, len(restored.Current()))
+}
+
+func TestStackRestoreSkipsCmdsThatFailToDecode(t *testing.T) {
+	saved := []SavedCmd{
+		{ID: 1, Kind: "unknown"},
+		{ID: 2, Kind: "saved", State: []byte("child"), Parent: 1},
+		{ID: 3, Kind: "saved", State: []byte("missing root"), Root: "root"},
+		{ID: 4, Kind: "saved", State: []byte("alone")},
+	}
+	s := NewStack()
+	err := s.Restore(saved, decodeSavedStateCmd)
+	assert(t, err != nil, "expected an error for the commands not restored")
+	equals(t, 1, len(s.Current()))
+	equals(t, "alone", s.Current()[0].(*SavedStateCmd).state)
+}
+
+func TestStackNotifiesChanges(t *testing.T) {
+	s := NewStack()
+	changes := 0
+	s.OnChange(func(s *Stack) { changes++ })
+
+	c := &BasicCmd{}
+	s.PushCmd(c, nil)
+	equals(t, 1, changes)
+	s.Pop(c)
+	equals(t, 2, changes)
+}
+
+func TestBoltStoreReplacesSavedStack(t *testing.T) {
+	f, err := ioutil.TempFile("", "stack")
+	ok(t, err)
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	ok(t, err)
+	defer os.Remove(f.Name())
+	defer db.Close()
+
+	store := NewBoltStore(db)
+	cmds, err := store.LoadStack()
+	ok(t, err)
+	equals(t, 0, len(cmds))
+
+	for i := 1; i <= 2; i++ {
+		ok(t, store.SaveStack([]SavedCmd{{ID: i, Kind: "saved", State: []byte(strconv.Itoa(i)), Root: "root"}}))
+	}
+	cmds, err = store.LoadStack()
+	ok(t, err)
+	equals(t, []SavedCmd{{ID: 2, Kind: "saved", State: []byte("2"), Root: "root"}}, cmds)
+}
@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+const _CONSOLE_DEFAULT_USER = "dev"
+
+// consoleLineRegexp matches "#room alice: hi", "#room: hi", "@alice: hi" and
+// "hi" capturing the room, sender and body.
+var consoleLineRegexp = regexp.MustCompile(`^(?:#(\S+)(?:\s+([^\s:]+))?:|@([^\s:]+):)?\s*(.*)$`)
+
+// ConsoleNetwork is a chat.Network for local development. Each line read is a
+// message. Lines starting with "#room alice:" are said by alice in room,
+// lines starting with "@alice:" are PMs from alice and any other line is a PM
+// from whoever spoke last. Messages the bot sends are written to out.
+type ConsoleNetwork struct {
+	sync.Mutex
+	out       io.Writer
+	user      string
+	lastID    int
+	messages  chan chat.InMsg
+	onConnect chan bool
+}
+
+func ConsoleConnect(in io.Reader, out io.Writer) *ConsoleNetwork {
+	c := &ConsoleNetwork{
+		out:       out,
+		user:      _CONSOLE_DEFAULT_USER,
+		messages:  make(chan chat.InMsg, 0),
+		onConnect: make(chan bool, 1),
+	}
+	c.onConnect <- true
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			if m, ok := c.parseLine(scanner.Text()); ok {
+				c.messages <- m
+			}
+		}
+		close(c.messages)
+	}()
+	return c
+}
+
+func (c *ConsoleNetwork) parseLine(line string) (chat.InMsg, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 {
+		return chat.InMsg{}, false
+	}
+	match := consoleLineRegexp.FindStringSubmatch(line)
+	room, roomUser, pmUser, body := match[1], match[2], match[3], match[4]
+
+	c.Lock()
+	defer c.Unlock()
+	if len(roomUser) > 0 {
+		c.user = roomUser
+	} else if len(pmUser) > 0 {
+		c.user = pmUser
+	}
+	c.lastID++
+	now := time.Now()
+	m := chat.InMsg{ID: fmt.Sprintf("console-%v", c.lastID), From: c.user, Body: body, ArrivedAt: now, SentAt: now}
+	if len(room) > 0 {
+		m.RoomID = &room
+	}
+	return m, true
+}
+
+func (c *ConsoleNetwork) print(to string, m chat.OutMsg) error {
+	body := m.Body
+	if m.HTML != nil && *m.HTML {
+		body = htmlToText(body)
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, line := range strings.Split(body, "\n") {
+		if _, err := fmt.Fprintf(c.out, "[%v] %v: %v\n", to, c.NickName(), line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ConsoleNetwork) NickName() string {
+	return "bot"
+}
+
+func (c *ConsoleNetwork) SendPM(m chat.OutMsg) error {
+	return c.print("@"+m.To, m)
+}
+
+// Send writes to any room, joined or not, so handlers can be exercised without
+// setting up rooms first.
+func (c *ConsoleNetwork) Send(m chat.OutMsg) error {
+	return c.print("#"+m.To, m)
+}
+
+func (c *ConsoleNetwork) JoinRoom(room string) error {
+	c.Lock()
+	defer c.Unlock()
+	_, err := fmt.Fprintf(c.out, "* joined #%v\n", strings.TrimPrefix(room, "#"))
+	return err
+}
+
+func (c *ConsoleNetwork) SetStatus(s string) error {
+	c.Lock()
+	defer c.Unlock()
+	_, err := fmt.Fprintf(c.out, "* status %v\n", s)
+	return err
+}
+
+func (c *ConsoleNetwork) Messages() <-chan chat.InMsg {
+	return c.messages
+}
+
+func (c *ConsoleNetwork) OnConnect() <-chan bool {
+	return c.onConnect
+}
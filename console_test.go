@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+func TestConsoleParsesLines(t *testing.T) {
+	in := strings.NewReader("hello\n#general alice: hi all\n\nnice weather\n@bob: whoami\n#random: still bob\n")
+	c := ConsoleConnect(in, &bytes.Buffer{})
+	<-c.OnConnect()
+
+	msgs := make([]chat.InMsg, 0)
+	for m := range c.Messages() {
+		msgs = append(msgs, m)
+	}
+	equals(t, 5, len(msgs))
+
+	equals(t, "dev", msgs[0].From)
+	equals(t, "hello", msgs[0].Body)
+	assert(t, msgs[0].IsPM(), "expected plain line to be a PM")
+
+	equals(t, "alice", msgs[1].From)
+	equals(t, "hi all", msgs[1].Body)
+	equals(t, "general", *msgs[1].RoomID)
+
+	equals(t, "alice", msgs[2].From)
+	assert(t, msgs[2].IsPM(), "expected plain line to be a PM")
+
+	equals(t, "bob", msgs[3].From)
+	equals(t, "whoami", msgs[3].Body)
+	assert(t, msgs[3].IsPM(), "expected @ line to be a PM")
+
+	equals(t, "bob", msgs[4].From)
+	equals(t, "random", *msgs[4].RoomID)
+	equals(t, "still bob", msgs[4].Body)
+}
+
+func TestConsoleWritesReplies(t *testing.T) {
+	out := &bytes.Buffer{}
+	c := ConsoleConnect(strings.NewReader(""), out)
+
+	ok(t, c.SendPM(chat.OutMsg{To: "alice", Body: "Howdy Ho!"}))
+	ok(t, c.Send(chat.OutMsg{To: "general", Body: "bye"}))
+	equals(t, "[@alice] bot: Howdy Ho!\n[#general] bot: bye\n", out.String())
+
+	out.Reset()
+	html := true
+	ok(t, c.Send(chat.OutMsg{To: "general", Body: "<b>Build</b> passed<br>see ci", HTML: &html}))
+	equals(t, "[#general] bot: Build passed\n[#general] bot: see ci\n", out.String())
+
+	out.Reset()
+	ok(t, c.JoinRoom("#random"))
+	ok(t, c.SetStatus("away"))
+	equals(t, "* joined #random\n* status away\n", out.String())
+}
@@ -0,0 +1,145 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mackross/go-bot/okr"
+)
+
+const (
+	_TIME_FORMAT  = "Mon Jan 2 2006 15:04"
+	_CHART_WIDTH  = 600
+	_CHART_HEIGHT = 200
+	_CHART_MARGIN = 30
+)
+
+type indexPage struct {
+	UserIDs []string
+	Token   string
+}
+
+type userPage struct {
+	Name string
+	OKRs []okrView
+}
+
+type okrView struct {
+	Title string
+	Specs []specView
+}
+
+type specView struct {
+	Question string
+	Schedule string
+	Starts   string
+	Ends     string
+	Chart    *chart
+	IsText   bool
+	Rows     []questionRow
+}
+
+type questionRow struct {
+	AskAt      string
+	AskedAt    string
+	AnsweredAt string
+	Answer     string
+}
+
+func newUserPage(name string, okrs []okr.OKR) userPage {
+	p := userPage{name, make([]okrView, 0, len(okrs))}
+	for _, o := range okrs {
+		v := okrView{o.Title, make([]specView, 0, len(o.QuestionSpecs))}
+		for _, spec := range o.QuestionSpecs {
+			v.Specs = append(v.Specs, newSpecView(spec))
+		}
+		p.OKRs = append(p.OKRs, v)
+	}
+	return p
+}
+
+func newSpecView(spec okr.QuestionSpec) specView {
+	v := specView{
+		Question: spec.Question,
+		Schedule: spec.Schedule,
+		Starts:   spec.Starts.Format(_TIME_FORMAT),
+		Ends:     spec.Ends.Format(_TIME_FORMAT),
+		IsText:   spec.AnswerType.IsText(),
+		Rows:     make([]questionRow, 0, len(spec.Questions)),
+	}
+	for _, q := range spec.Questions {
+		if q.AskedAt == nil {
+			continue
+		}
+		v.Rows = append(v.Rows, questionRow{q.AskAt.Format(_TIME_FORMAT), formatTime(q.AskedAt), formatTime(q.AnsweredAt), formatAnswer(q)})
+	}
+	if spec.AnswerType.IsRange() {
+		v.Chart = newChart(spec)
+	}
+	return v
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(_TIME_FORMAT)
+}
+
+func formatAnswer(q okr.Question) string {
+	if q.AnsweredAt == nil {
+		return ""
+	}
+	if b, ok := q.Answer.(bool); ok {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	return fmt.Sprintf("%v", q.Answer)
+}
+
+// chart is an SVG line chart of range answers over the life of a spec.
+type chart struct {
+	Width  int
+	Height int
+	Lower  string
+	Upper  string
+	Points []point
+	Line   string
+}
+
+type point struct {
+	X      float64
+	Y      float64
+	Answer string
+	Date   string
+}
+
+func newChart(spec okr.QuestionSpec) *chart {
+	lower, upper := spec.AnswerType.Range()
+	c := &chart{Width: _CHART_WIDTH, Height: _CHART_HEIGHT, Lower: fmt.Sprintf("%v", lower), Upper: fmt.Sprintf("%v", upper)}
+	duration := spec.Ends.Sub(spec.Starts).Seconds()
+	plotWidth := float64(_CHART_WIDTH - 2*_CHART_MARGIN)
+	plotHeight := float64(_CHART_HEIGHT - 2*_CHART_MARGIN)
+	coords := make([]string, 0)
+	for _, q := range spec.Questions {
+		f, ok := q.Answer.(float64)
+		if q.AnsweredAt == nil || !ok {
+			continue
+		}
+		x := float64(_CHART_MARGIN)
+		if duration > 0 {
+			x += q.AskAt.Sub(spec.Starts).Seconds() / duration * plotWidth
+		}
+		y := float64(_CHART_HEIGHT - _CHART_MARGIN)
+		if upper > lower {
+			y -= (f - lower) / (upper - lower) * plotHeight
+		}
+		c.Points = append(c.Points, point{x, y, fmt.Sprintf("%v", f), q.AskAt.Format(_TIME_FORMAT)})
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	c.Line = strings.Join(coords, " ")
+	return c
+}
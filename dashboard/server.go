@@ -0,0 +1,183 @@
+package dashboard
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/okr"
+	"github.com/mackross/go-bot/user"
+)
+
+const (
+	_DASHBOARD_MSG = "dashboard"
+
+	// ViewAllPermission lets users see everyone's OKRs, not just their own.
+	ViewAllPermission = "dashboard.all"
+)
+
+// Server is a read-only web view of every user's OKRs. Requests are
+// authenticated with a per-user token that the bot hands out by PM. Users can
+// see their own OKRs and admins can see everyone's.
+type Server struct {
+	okrs    okr.Repo
+	users   user.Repo
+	secret  []byte
+	baseURL string
+	mux     *http.ServeMux
+}
+
+// _MIN_SECRET_LEN is the shortest secret accepted, as anyone who can guess it
+// can forge a token for any user.
+const _MIN_SECRET_LEN = 16
+
+func NewServer(okrs okr.Repo, users user.Repo, secret []byte, baseURL string) (*Server, error) {
+	if len(secret) < _MIN_SECRET_LEN {
+		return nil, fmt.Errorf("dashboard secret must be at least %v bytes", _MIN_SECRET_LEN)
+	}
+	s := &Server{okrs, users, secret, strings.TrimRight(baseURL, "/"), http.NewServeMux()}
+	s.mux.HandleFunc("/", s.index)
+	s.mux.HandleFunc("/user/", s.userOKRs)
+	return s, nil
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Token returns the dashboard token of a user. Tokens are derived from the
+// server secret so changing the secret revokes every token.
+func (s *Server) Token(userID string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(userID))
+	return userID + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) URL(userID string) string {
+	return fmt.Sprintf("%v/user/%v?token=%v", s.baseURL, url.PathEscape(userID), url.QueryEscape(s.Token(userID)))
+}
+
+func (s *Server) viewer(r *http.Request) (*user.User, error) {
+	token := r.URL.Query().Get("token")
+	i := strings.LastIndex(token, ":")
+	if i == -1 || !hmac.Equal([]byte(token), []byte(s.Token(token[:i]))) {
+		return nil, nil
+	}
+	userID := token[:i]
+	u, err := s.users.UserForID(userID)
+	if err != nil && err != user.ErrNotFound {
+		return nil, err
+	}
+	if u == nil {
+		u = &user.User{ID: userID}
+	}
+	return u, nil
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) *user.User {
+	u, err := s.viewer(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if u == nil {
+		http.Error(w, "Invalid or missing token. PM the bot \""+_DASHBOARD_MSG+"\" for a link.", http.StatusForbidden)
+		return nil
+	}
+	return u
+}
+
+func (s *Server) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	viewer := s.authorize(w, r)
+	if viewer == nil {
+		return
+	}
+	if !viewer.Can(ViewAllPermission) {
+		http.Redirect(w, r, "/user/"+url.PathEscape(viewer.ID)+"?"+r.URL.RawQuery, http.StatusFound)
+		return
+	}
+	okrs, err := s.okrs.ListOKRs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	seen := make(map[string]bool, 0)
+	userIDs := make([]string, 0)
+	for _, o := range okrs {
+		if !seen[o.UserID] {
+			seen[o.UserID] = true
+			userIDs = append(userIDs, o.UserID)
+		}
+	}
+	sort.Strings(userIDs)
+	render(w, indexTemplate, indexPage{userIDs, r.URL.Query().Get("token")})
+}
+
+func (s *Server) userOKRs(w http.ResponseWriter, r *http.Request) {
+	viewer := s.authorize(w, r)
+	if viewer == nil {
+		return
+	}
+	userID, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/user/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if userID != viewer.ID && !viewer.Can(ViewAllPermission) {
+		http.Error(w, "You aren't allowed to view other users' OKRs.", http.StatusForbidden)
+		return
+	}
+	okrs, err := s.okrs.OKRsForUser(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := userID
+	if u, err := s.users.UserForID(userID); err == nil && u != nil && len(u.Name) > 0 {
+		name = u.Name
+	}
+	render(w, userTemplate, newUserPage(name, okrs))
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// NewRootHandler replies to a PM of "dashboard" with the sender's dashboard
+// link.
+func (s *Server) NewRootHandler() bot.MessageHandler {
+	r := bot.NewRouter(nil)
+	err := r.AddRoute(bot.Route{Pattern: _DASHBOARD_MSG, Help: "Get a login link for your OKR dashboard", PMOnly: true, Handle: s.sendLink})
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (s *Server) sendLink(b *bot.Bot, m chat.InMsg, args bot.Args) {
+	id, err := user.IDFor(m)
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to find you due to error: %v", err))
+		return
+	}
+	b.ReplyPM(m, "Your OKR dashboard is at "+s.URL(id)+"\nDon't share this link, it logs you in.")
+}
@@ -0,0 +1,108 @@
+package dashboard
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/okr"
+	"github.com/mackross/go-bot/user"
+)
+
+func testServer(t *testing.T) (*Server, func()) {
+	f, err := ioutil.TempFile("", "dashboard")
+	ok(t, err)
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	ok(t, err)
+
+	okrs := okr.NewBoltRepo(db)
+	users := user.NewBoltRepo(db)
+	user.SetRepo(users)
+	ok(t, users.SaveUser(user.User{ID: "batman", Name: "Bruce"}))
+	ok(t, users.SaveUser(user.User{ID: "alfred", Roles: []string{user.AdminRole}}))
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	fun := okr.QuestionSpec{Question: "How fun was this month?", Schedule: "0 9 L * *", Starts: jan1st2015, Ends: april1st2015, Questions: []okr.Question{okr.Question{Answer: float64(7), AskAt: jan31st, AskedAt: &jan31st, AnsweredAt: &jan31st}}, AnswerType: okr.RangeAnswerType(0, 10)}
+	diary := okr.QuestionSpec{Question: "What did you do?", Schedule: "0 9 L * *", Starts: jan1st2015, Ends: april1st2015, Questions: []okr.Question{okr.Question{Answer: "Caught the <b>Joker</b>", AskAt: jan31st, AskedAt: &jan31st, AnsweredAt: &jan31st}}, AnswerType: okr.TextAnswerType()}
+	ok(t, okrs.SaveOKR(okr.OKR{Title: "Fight crime", UserID: "batman", ID: "1", QuestionSpecs: []okr.QuestionSpec{fun, diary}}))
+
+	_, err = NewServer(okrs, users, nil, "http://okrs.example.com/")
+	assert(t, err != nil, "expected error for a missing secret")
+	s, err := NewServer(okrs, users, []byte("correct horse battery staple"), "http://okrs.example.com/")
+	ok(t, err)
+	return s, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func get(s *Server, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestDashboardRequiresToken(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	equals(t, http.StatusForbidden, get(s, "/user/batman").Code)
+	equals(t, http.StatusForbidden, get(s, "/user/batman?token=batman:deadbeef").Code)
+	equals(t, http.StatusForbidden, get(s, "/user/batman?token="+url.QueryEscape(s.Token("robin"))).Code)
+}
+
+func TestDashboardRendersUserOKRs(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	u, err := url.Parse(s.URL("batman"))
+	ok(t, err)
+	equals(t, "okrs.example.com", u.Host)
+
+	w := get(s, u.RequestURI())
+	equals(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert(t, strings.Contains(body, "<h1>Bruce's OKRs</h1>"), "missing user name in %v", body)
+	assert(t, strings.Contains(body, "<polyline"), "missing range chart in %v", body)
+	assert(t, strings.Contains(body, "Caught the &lt;b&gt;Joker&lt;/b&gt;"), "missing escaped text answer in %v", body)
+}
+
+func TestDashboardOnlyAdminsSeeOthers(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	robin := url.QueryEscape(s.Token("robin"))
+	equals(t, http.StatusForbidden, get(s, "/user/batman?token="+robin).Code)
+	equals(t, http.StatusFound, get(s, "/?token="+robin).Code)
+
+	alfred := url.QueryEscape(s.Token("alfred"))
+	equals(t, http.StatusOK, get(s, "/user/batman?token="+alfred).Code)
+	w := get(s, "/?token="+alfred)
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), `href="/user/batman?token=`), "missing user link in %v", w.Body.String())
+}
+
+func TestDashboardLinkIsSentByPM(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	c := bottest.NewChat(t)
+	b := bot.NewBot(c)
+	b.AddRootHandler(s.NewRootHandler())
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Your OKR dashboard is at " + s.URL("batman") + "\nDon't share this link, it logs you in."})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "Dashboard"})
+	c.Check()
+}
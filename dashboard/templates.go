@@ -0,0 +1,45 @@
+package dashboard
+
+import (
+	"html/template"
+)
+
+const _STYLE = `<style>
+body { font-family: sans-serif; margin: 2em; color: #333; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+svg { background: #fafafa; border: 1px solid #ccc; }
+.log p { margin: 0.3em 0; }
+.date { color: #999; font-size: 0.8em; }
+</style>`
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>OKRs</title>` + _STYLE + `</head><body>
+<h1>OKRs</h1>
+<ul>
+{{range .UserIDs}}<li><a href="/user/{{.}}?token={{$.Token}}">{{.}}</a></li>
+{{else}}<li>No one has any OKRs yet.</li>
+{{end}}</ul>
+</body></html>`))
+
+var userTemplate = template.Must(template.New("user").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Name}}'s OKRs</title>` + _STYLE + `</head><body>
+<h1>{{.Name}}'s OKRs</h1>
+{{range .OKRs}}<h2>{{.Title}}</h2>
+{{range .Specs}}<h3>{{.Question}}</h3>
+<p class="date">Asked on {{.Schedule}} from {{.Starts}} until {{.Ends}}</p>
+{{with .Chart}}<svg width="{{.Width}}" height="{{.Height}}" xmlns="http://www.w3.org/2000/svg">
+<text x="2" y="34" font-size="10">{{.Upper}}</text>
+<text x="2" y="{{.Height}}" dy="-30" font-size="10">{{.Lower}}</text>
+<polyline fill="none" stroke="#36c" stroke-width="2" points="{{.Line}}"/>
+{{range .Points}}<circle cx="{{.X}}" cy="{{.Y}}" r="4" fill="#36c"><title>{{.Date}}: {{.Answer}}</title></circle>
+{{end}}</svg>
+{{end}}{{if .IsText}}<div class="log">
+{{range .Rows}}{{if .AnsweredAt}}<p><span class="date">{{.AnsweredAt}}</span> {{.Answer}}</p>
+{{end}}{{end}}</div>
+{{else}}<table>
+<tr><th>Ask at</th><th>Asked at</th><th>Answered at</th><th>Answer</th></tr>
+{{range .Rows}}<tr><td>{{.AskAt}}</td><td>{{.AskedAt}}</td><td>{{.AnsweredAt}}</td><td>{{.Answer}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{else}}<p>No OKRs yet. PM the bot "add okr" to add one.</p>
+{{end}}</body></html>`))
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+// _ERROR_MSG is sent to the sender of a message that a handler failed on.
+// Error details are only logged as they may reveal internals.
+const _ERROR_MSG = "Sorry, something went wrong handling that. Please try again later."
+
+// ErrorHandler is a handler that reports failures by returning an error
+// rather than panicking. Use HandleErrors to add one to the bot.
+type ErrorHandler interface {
+	HandleMessageErr(b *Bot, m chat.InMsg) (bool, error)
+}
+
+// HandleErrors adapts h to a MessageHandler. When h returns an error it's
+// logged, the sender is told something went wrong and the message is treated
+// as handled.
+func HandleErrors(h ErrorHandler) MessageHandler {
+	return &errorHandler{h}
+}
+
+type errorHandler struct {
+	handler ErrorHandler
+}
+
+func (e *errorHandler) HandleMessage(b *Bot, m chat.InMsg) bool {
+	handled, err := e.handler.HandleMessageErr(b, m)
+	if err != nil {
+		b.reportError(m, err)
+		return true
+	}
+	return handled
+}
+
+// reportError logs err and tells the sender of m something went wrong.
+func (b *Bot) reportError(m chat.InMsg, err error) {
+	fmt.Printf("Error handling message %v from %v: %v\n", m.ID, m.From, err)
+	b.ReplyPM(m, _ERROR_MSG)
+}
+
+func (e *errorHandler) Help(m chat.InMsg) []Route {
+	if h, ok := e.handler.(HelpProvider); ok {
+		return h.Help(m)
+	}
+	return nil
+}
+
+// recoverMessage stops a panic handling m from taking down the bot. It logs
+// the stack trace and tells the sender something went wrong.
+func (b *Bot) recoverMessage(m chat.InMsg) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	fmt.Printf("Panic handling message %v from %v: %v\n%s", m.ID, m.From, r, debug.Stack())
+	defer func() {
+		// the network may be what's failing
+		if r := recover(); r != nil {
+			fmt.Printf("Unable to report panic to %v: %v\n", m.From, r)
+		}
+	}()
+	b.ReplyPM(m, _ERROR_MSG)
+}
+
+// recoverExpiry stops a panic in a parent told its child timed out from
+// taking down the bot.
+func (b *Bot) recoverExpiry() {
+	if r := recover(); r != nil {
+		fmt.Printf("Panic popping expired handlers: %v\n%s", r, debug.Stack())
+	}
+}
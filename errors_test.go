@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+)
+
+type failingHandler struct {
+	err error
+}
+
+func (f *failingHandler) HandleMessageErr(b *Bot, m chat.InMsg) (bool, error) {
+	if m.Body == "fail" {
+		return false, f.err
+	}
+	return false, nil
+}
+
+type panickingHandler struct{}
+
+func (p *panickingHandler) HandleMessage(b *Bot, m chat.InMsg) bool {
+	if m.Body == "boom" {
+		var u *struct{ name string }
+		return u.name == ""
+	}
+	return false
+}
+
+func TestPanicsAreRecoveredPerMessage(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	b.AddRootHandler(&panickingHandler{})
+	b.AddRootHandler(NewGreeter("Towlie", "Howdy Ho!"))
+
+	room := "general"
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: _ERROR_MSG})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Howdy Ho!"})
+	b.HandleMessage(chat.InMsg{From: "robin", RoomID: &room, Body: "boom"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "Hello Towlie"})
+
+	c.Check()
+}
+
+func TestErrorHandlersReplyWithError(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	b.AddRootHandler(HandleErrors(&failingHandler{errors.New("disk full")}))
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: _ERROR_MSG})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "fail"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "succeed"})
+
+	c.Check()
+}
@@ -0,0 +1,18 @@
+package bot
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlTagRegexp     = regexp.MustCompile(`<[^>]*>`)
+	htmlBreakReplacer = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n")
+)
+
+// htmlToText turns line breaks in HTML into newlines, drops every other tag
+// and decodes entities, for networks that only take plain text.
+func htmlToText(body string) string {
+	return html.UnescapeString(htmlTagRegexp.ReplaceAllString(htmlBreakReplacer.Replace(body), ""))
+}
@@ -0,0 +1,11 @@
+package bot
+
+import (
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	equals(t, "Build passed\nsee ci", htmlToText(`<b>Build</b> passed<br/>see <a href="http://ci">ci</a>`))
+	equals(t, "a < b && \"c\" > d", htmlToText("a &lt; b &amp;&amp; &quot;c&quot; &gt; d"))
+	equals(t, "<b> stays escaped", htmlToText("&lt;b&gt; stays escaped"))
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+const _HELP_MSG = "help"
+
+// HelpProvider is implemented by root handlers that list their commands in
+// help. Router is one.
+type HelpProvider interface {
+	// Help returns the commands the sender of m may run.
+	Help(m chat.InMsg) []Route
+}
+
+// Help returns the documented commands of every root handler the sender of m
+// may run, in the order the handlers were added.
+func (b *Bot) Help(m chat.InMsg) []Route {
+	b.handlerMu.Lock()
+	roots := append([]MessageHandler{}, b.roots...)
+	b.handlerMu.Unlock()
+	routes := make([]Route, 0)
+	for _, root := range roots {
+		if p, ok := root.(HelpProvider); ok {
+			routes = append(routes, p.Help(m)...)
+		}
+	}
+	return routes
+}
+
+// Help lists the routes with help text that the sender of m is allowed to run.
+func (r *Router) Help(m chat.InMsg) []Route {
+	routes := make([]Route, 0)
+	for _, route := range r.routes {
+		if len(route.Help) > 0 && r.Allowed(m, *route) {
+			routes = append(routes, *route)
+		}
+	}
+	return routes
+}
+
+// helpHandler replies to a PM of "help" with the commands the sender may run
+// and to "help <command>" with the usage of matching commands.
+type helpHandler struct{}
+
+func (h *helpHandler) HandleMessage(b *Bot, m chat.InMsg) bool {
+	if !m.IsPM() {
+		return false
+	}
+	tokens, err := tokenize(m.Body)
+	if err != nil || len(tokens) == 0 || strings.ToLower(tokens[0]) != _HELP_MSG {
+		return false
+	}
+	routes := b.Help(m)
+	if len(tokens) == 1 {
+		if len(routes) == 0 {
+			b.ReplyPM(m, "There are no commands you can use.")
+			return true
+		}
+		lines := []string{"Commands you can use:"}
+		for _, r := range routes {
+			lines = append(lines, fmt.Sprintf("%v - %v", r.Pattern, r.Help))
+		}
+		lines = append(lines, `Say "help <command>" for more.`)
+		b.ReplyPM(m, strings.Join(lines, "\n"))
+		return true
+	}
+	lines := make([]string, 0)
+	for _, r := range routes {
+		if r.startsWith(tokens[1:]) {
+			lines = append(lines, fmt.Sprintf("Usage: %v\n%v", r.Usage(), r.Help))
+		}
+	}
+	if len(lines) == 0 {
+		b.ReplyPM(m, fmt.Sprintf(`No command named %v. Say "help" to see the commands you can use.`, strings.Join(tokens[1:], " ")))
+		return true
+	}
+	b.ReplyPM(m, strings.Join(lines, "\n"))
+	return true
+}
+
+// startsWith reports whether the route's words begin with words, so "help
+// toggle" finds both "toggle flag" and "toggle admin".
+func (r *Route) startsWith(words []string) bool {
+	if len(words) > len(r.words) {
+		return false
+	}
+	for i, w := range words {
+		if strings.ToLower(w) != r.words[i] {
+			return false
+		}
+	}
+	return true
+}
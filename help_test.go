@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+)
+
+func TestHelpListsAllowedCommands(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	noop := func(b *Bot, m chat.InMsg, args Args) {}
+	isAdmin := func(m chat.InMsg, permission string) bool { return m.From == "batman" && permission == "admin" }
+	r := NewRouter(isAdmin)
+	ok(t, r.AddRoute(Route{Pattern: "whoami", Help: "Show who you are", Handle: noop}))
+	ok(t, r.AddRoute(Route{Pattern: "toggle flag <user> <flag>", Help: "Toggle a flag", Permission: "admin", Handle: noop}))
+	ok(t, r.AddRoute(Route{Pattern: "toggle admin <user>", Help: "Toggle an admin", Permission: "admin", Handle: noop}))
+	ok(t, r.AddRoute(Route{Pattern: "secret", Handle: noop}))
+	b.AddRootHandler(r)
+	b.AddRootHandler(NewGreeter("Towlie", "Howdy Ho!"))
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Commands you can use:\nwhoami - Show who you are\nSay \"help <command>\" for more."})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "help"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Commands you can use:\nwhoami - Show who you are\ntoggle flag <user> <flag> - Toggle a flag\ntoggle admin <user> - Toggle an admin\nSay \"help <command>\" for more."})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "Help"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Usage: toggle flag <user> <flag>\nToggle a flag\nUsage: toggle admin <user>\nToggle an admin"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "help toggle"})
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "No command named toggle flag. Say \"help\" to see the commands you can use."})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "help toggle flag"})
+
+	// help isn't answered in rooms
+	room := "general"
+	b.HandleMessage(chat.InMsg{From: "robin", RoomID: &room, Body: "help"})
+
+	c.Check()
+}
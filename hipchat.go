@@ -154,7 +154,7 @@ func (h *HipChatNetwork) roomNameFromXMPJID(id string) (string, error) {
 		return name, nil
 	}
 
-	return "", fmt.Errorf("Unable to find room with xmpjid:", id)
+	return "", fmt.Errorf("Unable to find room with xmpjid: %v", id)
 }
 
 func (h *HipChatNetwork) mentionNameFromXMPJID(id string) (string, error) {
@@ -170,7 +170,7 @@ func (h *HipChatNetwork) mentionNameFromXMPJID(id string) (string, error) {
 		return name, nil
 	}
 
-	return "", fmt.Errorf("Unable to find user with xmpjid:", id)
+	return "", fmt.Errorf("Unable to find user with xmpjid: %v", id)
 }
 
 func (h *HipChatNetwork) SendPM(m chat.OutMsg) error {
@@ -0,0 +1,453 @@
+package bot
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+// IRC limits a whole line to 512 bytes including the command and prefix, so
+// message bodies are split well under that.
+const _IRC_MAX_BODY = 400
+
+type IRCConfig struct {
+	Server    string // host:port
+	Nick      string
+	User      string
+	RealName  string
+	Password  string // NickServ password, not sent when empty
+	TLS       bool
+	LineDelay time.Duration // minimum time between PRIVMSG lines, defaults to 500ms
+}
+
+// IRCNetwork is a chat.Network over a single IRC server connection. Rooms are
+// addressed by channel name (e.g. #general) and users by nick. Lost connections
+// are redialled and previously joined channels rejoined. Messages sent while
+// disconnected are held until the bot is back.
+type IRCNetwork struct {
+	lastID uint64 // first for 64 bit alignment of atomic access
+	sync.RWMutex
+	config    IRCConfig
+	nick      string
+	conn      net.Conn
+	ready     chan struct{} // closed once registered with the server
+	writeMu   sync.Mutex
+	rooms     map[string]bool
+	out       chan string
+	incoming  chan chat.InMsg
+	messages  chan chat.InMsg
+	onConnect chan bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func IRCConnect(c IRCConfig) *IRCNetwork {
+	if len(c.User) == 0 {
+		c.User = c.Nick
+	}
+	if len(c.RealName) == 0 {
+		c.RealName = c.Nick
+	}
+	if c.LineDelay == 0 {
+		c.LineDelay = 500 * time.Millisecond
+	}
+	i := &IRCNetwork{
+		config:    c,
+		nick:      c.Nick,
+		rooms:     make(map[string]bool, 0),
+		ready:     make(chan struct{}),
+		out:       make(chan string, 100),
+		incoming:  make(chan chat.InMsg, 0),
+		messages:  make(chan chat.InMsg, 0),
+		onConnect: make(chan bool, 1),
+		done:      make(chan struct{}),
+	}
+	go i.writeLines()
+	go i.deliver()
+	go func() {
+		for {
+			fmt.Println("Attempting to connect to", c.Server, "as", c.Nick)
+			if err := i.connectAndRead(); err != nil && !i.closed() {
+				fmt.Println("IRC connection lost:", err)
+			}
+			if i.closed() {
+				return
+			}
+			fmt.Println("Retrying in 3 seconds")
+			select {
+			case <-time.After(3 * time.Second):
+			case <-i.done:
+				return
+			}
+		}
+	}()
+	return i
+}
+
+// Close disconnects from the server and stops reconnecting. Messages is
+// closed and anything still waiting to be sent is dropped.
+func (i *IRCNetwork) Close() error {
+	i.closeOnce.Do(func() {
+		close(i.done)
+		i.RLock()
+		conn := i.conn
+		i.RUnlock()
+		if conn != nil {
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+func (i *IRCNetwork) closed() bool {
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver queues received messages for the bot so a bot busy handling one
+// doesn't hold up the read loop, which has PINGs to answer.
+func (i *IRCNetwork) deliver() {
+	defer close(i.messages)
+	queue := make([]chat.InMsg, 0)
+	for {
+		var out chan chat.InMsg
+		var next chat.InMsg
+		if len(queue) > 0 {
+			out, next = i.messages, queue[0]
+		}
+		select {
+		case m := <-i.incoming:
+			queue = append(queue, m)
+		case out <- next:
+			queue = queue[1:]
+		case <-i.done:
+			return
+		}
+	}
+}
+
+func (i *IRCNetwork) connectAndRead() error {
+	var conn net.Conn
+	var err error
+	if i.config.TLS {
+		conn, err = tls.Dial("tcp", i.config.Server, nil)
+	} else {
+		conn, err = net.DialTimeout("tcp", i.config.Server, 10*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	i.Lock()
+	i.conn = conn
+	i.nick = i.config.Nick
+	i.Unlock()
+	defer func() {
+		i.Lock()
+		i.conn = nil
+		i.ready = make(chan struct{})
+		i.Unlock()
+	}()
+	// Close may have missed the connection while it was being made
+	if i.closed() {
+		return nil
+	}
+
+	i.raw("NICK " + i.config.Nick)
+	i.raw(fmt.Sprintf("USER %v 0 * :%v", i.config.User, i.config.RealName))
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		i.handleLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed")
+}
+
+type ircLine struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+func parseIRCLine(line string) ircLine {
+	l := ircLine{}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, ":") {
+		split := strings.SplitN(line[1:], " ", 2)
+		l.prefix = split[0]
+		line = ""
+		if len(split) == 2 {
+			line = split[1]
+		}
+	}
+	var trailing *string
+	if idx := strings.Index(line, " :"); idx != -1 {
+		t := line[idx+2:]
+		trailing = &t
+		line = line[:idx]
+	} else if strings.HasPrefix(line, ":") {
+		t := line[1:]
+		trailing = &t
+		line = ""
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		l.command = strings.ToUpper(fields[0])
+		l.params = fields[1:]
+	}
+	if trailing != nil {
+		l.params = append(l.params, *trailing)
+	}
+	return l
+}
+
+func (i *IRCNetwork) handleLine(raw string) {
+	start := time.Now()
+	l := parseIRCLine(raw)
+	switch l.command {
+	case "PING":
+		i.raw("PONG :" + strings.Join(l.params, " "))
+	case "001": // RPL_WELCOME
+		i.Lock()
+		if len(l.params) > 0 {
+			i.nick = l.params[0]
+		}
+		rooms := make([]string, 0, len(i.rooms))
+		for r := range i.rooms {
+			rooms = append(rooms, r)
+		}
+		i.Unlock()
+		if len(i.config.Password) > 0 {
+			i.raw("PRIVMSG NickServ :IDENTIFY " + i.config.Password)
+		}
+		for _, r := range rooms {
+			i.raw("JOIN " + r)
+		}
+		i.Lock()
+		close(i.ready)
+		i.Unlock()
+		fmt.Println("Connected")
+		select {
+		case i.onConnect <- true:
+		default:
+		}
+	case "433": // ERR_NICKNAMEINUSE
+		i.Lock()
+		i.nick += "_"
+		nick := i.nick
+		i.Unlock()
+		i.raw("NICK " + nick)
+	case "NICK":
+		if ircNick(l.prefix) == i.NickName() && len(l.params) > 0 {
+			i.Lock()
+			i.nick = l.params[0]
+			i.Unlock()
+		}
+	case "PRIVMSG":
+		if len(l.params) < 2 {
+			return
+		}
+		from, target, body := ircNick(l.prefix), l.params[0], l.params[1]
+		// CTCP requests such as VERSION are wrapped in \x01
+		if strings.HasPrefix(body, "\x01") || from == i.NickName() {
+			return
+		}
+		m := chat.InMsg{ID: i.newID(), From: from, Body: body, ArrivedAt: start, SentAt: start}
+		if isIRCChannel(target) {
+			m.RoomID = &target
+		}
+		select {
+		case i.incoming <- m:
+		case <-i.done:
+		}
+	}
+}
+
+func (i *IRCNetwork) newID() string {
+	return fmt.Sprintf("irc-%v-%v", time.Now().UnixNano(), atomic.AddUint64(&i.lastID, 1))
+}
+
+func ircNick(prefix string) string {
+	return strings.SplitN(prefix, "!", 2)[0]
+}
+
+func isIRCChannel(s string) bool {
+	return strings.HasPrefix(s, "#") || strings.HasPrefix(s, "&")
+}
+
+// ircChannel returns the channel for a room name, adding a # when it doesn't
+// already start with # or &.
+func ircChannel(room string) string {
+	if isIRCChannel(room) {
+		return room
+	}
+	return "#" + room
+}
+
+// raw writes a line straight to the connection, bypassing flood protection.
+func (i *IRCNetwork) raw(line string) error {
+	i.RLock()
+	conn := i.conn
+	i.RUnlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+	_, err := conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// writeLines drains the outgoing queue no faster than one line per LineDelay
+// so servers don't kick the bot for flooding. Lines wait while the bot is
+// disconnected and are sent once it has reconnected.
+func (i *IRCNetwork) writeLines() {
+	for {
+		var line string
+		select {
+		case line = <-i.out:
+		case <-i.done:
+			return
+		}
+		for {
+			i.RLock()
+			ready := i.ready
+			i.RUnlock()
+			select {
+			case <-ready:
+			case <-i.done:
+				return
+			}
+			err := i.raw(line)
+			time.Sleep(i.config.LineDelay)
+			if err == nil {
+				break
+			}
+			fmt.Println("Unable to send to IRC, retrying after reconnecting:", err)
+		}
+	}
+}
+
+// ircUnsafe are the characters that would end a line early and let text sent
+// on behalf of others, such as relayed messages, add commands of its own.
+const ircUnsafe = "\r\n\x00"
+
+var ircUnsafeRemover = strings.NewReplacer("\r", "", "\n", "", "\x00", "")
+
+func checkIRCParam(name, s string) error {
+	if strings.ContainsAny(s, ircUnsafe) {
+		return fmt.Errorf("%v %q contains a line break or NUL", name, s)
+	}
+	return nil
+}
+
+func (i *IRCNetwork) privmsg(target string, m chat.OutMsg) error {
+	if err := checkIRCParam("target", target); err != nil {
+		return err
+	}
+	body := m.Body
+	if m.HTML != nil && *m.HTML {
+		body = htmlToText(body)
+	}
+	for _, line := range strings.Split(body, "\n") {
+		line = ircUnsafeRemover.Replace(line)
+		if len(line) == 0 {
+			continue
+		}
+		for len(line) > _IRC_MAX_BODY {
+			// don't split a multibyte character between lines
+			n := _IRC_MAX_BODY
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+			if err := i.queue(fmt.Sprintf("PRIVMSG %v :%v", target, line[:n])); err != nil {
+				return err
+			}
+			line = line[n:]
+		}
+		if err := i.queue(fmt.Sprintf("PRIVMSG %v :%v", target, line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errIRCClosed = errors.New("irc network is closed")
+
+func (i *IRCNetwork) queue(line string) error {
+	if i.closed() {
+		return errIRCClosed
+	}
+	select {
+	case i.out <- line:
+		return nil
+	case <-i.done:
+		return errIRCClosed
+	}
+}
+
+func (i *IRCNetwork) NickName() string {
+	i.RLock()
+	defer i.RUnlock()
+	return i.nick
+}
+
+func (i *IRCNetwork) SendPM(m chat.OutMsg) error {
+	if isIRCChannel(m.To) {
+		return fmt.Errorf("Unable to PM channel %v", m.To)
+	}
+	return i.privmsg(m.To, m)
+}
+
+// Send messages a channel. As with JoinRoom the # may be omitted.
+func (i *IRCNetwork) Send(m chat.OutMsg) error {
+	return i.privmsg(ircChannel(m.To), m)
+}
+
+// JoinRoom joins a channel now and again whenever the bot reconnects. The #
+// may be omitted.
+func (i *IRCNetwork) JoinRoom(room string) error {
+	if err := checkIRCParam("room", room); err != nil {
+		return err
+	}
+	room = ircChannel(room)
+	i.Lock()
+	i.rooms[room] = true
+	i.Unlock()
+	return i.raw("JOIN " + room)
+}
+
+func (i *IRCNetwork) SetStatus(s string) error {
+	if err := checkIRCParam("status", s); err != nil {
+		return err
+	}
+	if len(s) == 0 || s == "chat" || s == "available" {
+		return i.raw("AWAY")
+	}
+	return i.raw("AWAY :" + s)
+}
+
+func (i *IRCNetwork) Messages() <-chan chat.InMsg {
+	return i.messages
+}
+
+func (i *IRCNetwork) OnConnect() <-chan bool {
+	return i.onConnect
+}
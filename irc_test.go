@@ -0,0 +1,195 @@
+package bot
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+// fakeIRC accepts connections one at a time and exposes the lines the client
+// sends. The nick "botty" is always taken so clients must handle collisions.
+type fakeIRC struct {
+	listener net.Listener
+	conns    chan net.Conn
+	lines    chan string
+}
+
+func newFakeIRC(t *testing.T) *fakeIRC {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	f := &fakeIRC{l, make(chan net.Conn, 10), make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.conns <- conn
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeIRC) serve(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "NICK botty":
+			fmt.Fprintf(conn, ":irc.example.com 433 * botty :Nickname is already in use\r\n")
+		case strings.HasPrefix(line, "NICK "):
+			fmt.Fprintf(conn, ":irc.example.com 001 %v :Welcome\r\n", strings.TrimPrefix(line, "NICK "))
+			fmt.Fprintf(conn, "PING :irc.example.com\r\n")
+		}
+		f.lines <- line
+	}
+}
+
+// expect waits for the client to send line, skipping any others.
+func (f *fakeIRC) expect(t *testing.T, line string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case l := <-f.lines:
+			if l == line {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", line)
+		}
+	}
+}
+
+// linesUntil returns the lines the client sends before line.
+func (f *fakeIRC) linesUntil(t *testing.T, line string) []string {
+	lines := make([]string, 0)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case l := <-f.lines:
+			if l == line {
+				return lines
+			}
+			lines = append(lines, l)
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", line)
+		}
+	}
+}
+
+func nextIRCMessage(t *testing.T, i *IRCNetwork) chat.InMsg {
+	select {
+	case m := <-i.Messages():
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return chat.InMsg{}
+}
+
+func TestParseIRCLine(t *testing.T) {
+	l := parseIRCLine(":alice!a@host PRIVMSG #general :hi there: all\r\n")
+	equals(t, ircLine{"alice!a@host", "PRIVMSG", []string{"#general", "hi there: all"}}, l)
+	equals(t, ircLine{"", "PING", []string{"irc.example.com"}}, parseIRCLine("PING :irc.example.com"))
+	equals(t, ircLine{"irc.example.com", "001", []string{"botty_", "Welcome"}}, parseIRCLine(":irc.example.com 001 botty_ :Welcome"))
+}
+
+func TestIRCRegistersJoinsAndChats(t *testing.T) {
+	f := newFakeIRC(t)
+	defer f.listener.Close()
+
+	i := IRCConnect(IRCConfig{Server: f.listener.Addr().String(), Nick: "botty", Password: "hunter2", LineDelay: time.Millisecond})
+	var conn net.Conn
+	select {
+	case conn = <-f.conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for connection")
+	}
+	f.expect(t, "USER botty 0 * :botty")
+	f.expect(t, "NICK botty_")
+	f.expect(t, "PRIVMSG NickServ :IDENTIFY hunter2")
+	f.expect(t, "PONG :irc.example.com")
+	<-i.OnConnect()
+	equals(t, "botty_", i.NickName())
+
+	ok(t, i.JoinRoom("general"))
+	f.expect(t, "JOIN #general")
+
+	fmt.Fprintf(conn, ":alice!a@host PRIVMSG #general :hi all\r\n")
+	m := nextIRCMessage(t, i)
+	equals(t, "alice", m.From)
+	equals(t, "hi all", m.Body)
+	equals(t, "#general", *m.RoomID)
+
+	fmt.Fprintf(conn, ":bob!b@host PRIVMSG botty_ :\x01VERSION\x01\r\n")
+	fmt.Fprintf(conn, ":bob!b@host PRIVMSG botty_ :whoami\r\n")
+	m = nextIRCMessage(t, i)
+	equals(t, "bob", m.From)
+	assert(t, m.IsPM(), "expected message to nick to be a PM")
+
+	ok(t, i.Send(chat.OutMsg{To: "#general", Body: "line one\nline two"}))
+	f.expect(t, "PRIVMSG #general :line one")
+	f.expect(t, "PRIVMSG #general :line two")
+	ok(t, i.SendPM(chat.OutMsg{To: "bob", Body: "psst"}))
+	f.expect(t, "PRIVMSG bob :psst")
+	assert(t, i.SendPM(chat.OutMsg{To: "&ops", Body: "psst"}) != nil, "expected error sending a PM to a channel")
+	ok(t, i.Send(chat.OutMsg{To: "general", Body: "hi"}))
+	f.expect(t, "PRIVMSG #general :hi")
+	ok(t, i.Send(chat.OutMsg{To: "&ops", Body: "hi"}))
+	f.expect(t, "PRIVMSG &ops :hi")
+
+	// long lines are split without breaking up characters
+	ok(t, i.Send(chat.OutMsg{To: "#general", Body: "x" + strings.Repeat("é", 250)}))
+	f.expect(t, "PRIVMSG #general :x"+strings.Repeat("é", 199))
+	f.expect(t, "PRIVMSG #general :"+strings.Repeat("é", 51))
+
+	// text can't end the line early to add commands of its own
+	ok(t, i.Send(chat.OutMsg{To: "#general", Body: "hi\rQUIT\x00"}))
+	ok(t, i.Send(chat.OutMsg{To: "#general", Body: "hi\r\nQUIT"}))
+	assert(t, i.Send(chat.OutMsg{To: "#general\r\nQUIT", Body: "hi"}) != nil, "expected error sending to a target with a line break")
+	assert(t, i.SendPM(chat.OutMsg{To: "bob\nQUIT", Body: "hi"}) != nil, "expected error sending to a target with a line break")
+	assert(t, i.JoinRoom("general\r\nQUIT") != nil, "expected error joining a room with a line break")
+	assert(t, i.SetStatus("away\r\nQUIT") != nil, "expected error setting a status with a line break")
+	ok(t, i.SendPM(chat.OutMsg{To: "bob", Body: "done"}))
+	equals(t, []string{"PRIVMSG #general :hiQUIT", "PRIVMSG #general :hi", "PRIVMSG #general :QUIT"}, f.linesUntil(t, "PRIVMSG bob :done"))
+
+	// dropped connections are redialled, channels rejoined and what was sent
+	// in between is sent once the bot is back
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	ok(t, i.Send(chat.OutMsg{To: "#general", Body: "back soon"}))
+	select {
+	case conn = <-f.conns:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for reconnection")
+	}
+	f.expect(t, "NICK botty_")
+	f.expect(t, "JOIN #general")
+	f.expect(t, "PRIVMSG #general :back soon")
+
+	// PINGs are answered while the bot is still busy with earlier messages
+	for n := 0; n < 3; n++ {
+		fmt.Fprintf(conn, ":alice!a@host PRIVMSG #general :%v\r\n", n)
+	}
+	fmt.Fprintf(conn, "PING :busy\r\n")
+	f.expect(t, "PONG :busy")
+	for n := 0; n < 3; n++ {
+		equals(t, fmt.Sprint(n), nextIRCMessage(t, i).Body)
+	}
+
+	ok(t, i.Close())
+	ok(t, i.Close())
+	select {
+	case _, open := <-i.Messages():
+		assert(t, !open, "expected no messages after closing")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for messages to close")
+	}
+	assert(t, i.Send(chat.OutMsg{To: "#general", Body: "hello?"}) != nil, "expected error sending after closing")
+}
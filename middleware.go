@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+// HandlerFunc lets an ordinary function be used as a MessageHandler in
+// middleware. Funcs can't be compared so they can't be added to the bot.
+type HandlerFunc func(b *Bot, m chat.InMsg) bool
+
+func (f HandlerFunc) HandleMessage(b *Bot, m chat.InMsg) bool {
+	return f(b, m)
+}
+
+// Middleware wraps the handling of every message. next offers the message to
+// the handlers and reports whether any handled it. Middleware may act before
+// or after calling next, or not call it at all to stop the handlers seeing the
+// message.
+type Middleware func(next MessageHandler) MessageHandler
+
+// Use adds middleware around the handling of every message. The first added is
+// outermost.
+func (b *Bot) Use(mw ...Middleware) {
+	b.handlerMu.Lock()
+	defer b.handlerMu.Unlock()
+	b.middleware = append(b.middleware, mw...)
+}
+
+// chain wraps h in the bot's middleware.
+func (b *Bot) chain(h MessageHandler) MessageHandler {
+	b.handlerMu.Lock()
+	mw := b.middleware
+	b.handlerMu.Unlock()
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// LogMessages writes each message and, when it's known when the message
+// arrived, how long it took to handle. NewBot uses it with stdout.
+func LogMessages(w io.Writer) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return HandlerFunc(func(b *Bot, m chat.InMsg) bool {
+			handled := next.HandleMessage(b, m)
+			roomID := "PM"
+			if m.RoomID != nil {
+				roomID = *m.RoomID
+			}
+			if len(m.Network) > 0 {
+				roomID = m.Network + "/" + roomID
+			}
+			if m.ArrivedAt.IsZero() {
+				fmt.Fprintf(w, "<%v (%v)> %v\n", m.From, roomID, m.Body)
+			} else {
+				fmt.Fprintf(w, "<%v (%v)> %v [Handled in %v]\n", m.From, roomID, m.Body, time.Since(m.ArrivedAt))
+			}
+			return handled
+		})
+	}
+}
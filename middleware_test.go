@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+)
+
+func TestMiddlewareWrapsEveryMessage(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	calls := make([]string, 0)
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return HandlerFunc(func(b *Bot, m chat.InMsg) bool {
+				calls = append(calls, name+" before")
+				handled := next.HandleMessage(b, m)
+				calls = append(calls, name+" after")
+				return handled
+			})
+		}
+	}
+	muteJoker := func(next MessageHandler) MessageHandler {
+		return HandlerFunc(func(b *Bot, m chat.InMsg) bool {
+			return m.From != "joker" && next.HandleMessage(b, m)
+		})
+	}
+	b.Use(trace("outer"), trace("inner"))
+	b.Use(muteJoker)
+
+	p := &poppableHandler{}
+	b.PushHandler(p, nil)
+	b.AddRootHandler(NewGreeter("Towlie", "Howdy Ho!"))
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Howdy Ho!"})
+	b.HandleMessage(chat.InMsg{From: "joker", Body: "Hello Towlie"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "Hello Towlie"})
+	c.Check()
+
+	// once per message however many handlers see it
+	equals(t, 4, len(calls)/2)
+	equals(t, []string{"outer before", "inner before", "inner after", "outer after"}, calls[:4])
+
+	p.popOnMsg = true
+	b.HandleMessage(chat.InMsg{From: "joker"})
+	equals(t, 0, p.childPopped)
+	assert(t, len(b.cmdStack.Current()) == 1, "expected joker not to reach the pushed handler")
+}
+
+func TestLogMessages(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	var out bytes.Buffer
+	b.Use(LogMessages(&out))
+	b.AddRootHandler(NewGreeter("Towlie", "Howdy Ho!"))
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Howdy Ho!"})
+	room := "general"
+	b.HandleMessage(chat.InMsg{From: "joker", RoomID: &room, Body: "why so serious?"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "Hello Towlie", ArrivedAt: time.Now()})
+	c.Check()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	equals(t, 2, len(lines))
+	equals(t, "<joker (general)> why so serious?", lines[0])
+	assert(t, strings.HasPrefix(lines[1], "<robin (PM)> Hello Towlie [Handled in "), "unexpected log %q", lines[1])
+}
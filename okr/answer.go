@@ -0,0 +1,111 @@
+package okr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var yesAnswers = []string{"yes", "y", "yeah", "yea", "yep", "yup", "sure", "true", "affirmative", "of course", "definitely"}
+var noAnswers = []string{"no", "n", "nope", "nah", "no way", "false", "negative", "not really", "not at all"}
+
+var numberWords = map[string]float64{
+	"zero": 0, "none": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11,
+	"twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15, "sixteen": 16,
+	"seventeen": 17, "eighteen": 18, "nineteen": 19, "twenty": 20, "thirty": 30,
+	"forty": 40, "fifty": 50, "sixty": 60, "seventy": 70, "eighty": 80,
+	"ninety": 90, "hundred": 100,
+}
+
+// parseAnswer turns a chat reply into a typed answer. When the reply can't be
+// understood the error says what the user should reply with instead.
+func (a answerType) parseAnswer(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if a.isBoolAnswer() {
+		return parseBoolAnswer(trimPunctuation(s))
+	} else if a.isRangeAnswer() {
+		return a.parseRangeAnswer(trimPunctuation(s))
+	}
+	if len(s) == 0 {
+		return nil, errors.New("please reply with some text")
+	}
+	return s, nil
+}
+
+func parseBoolAnswer(s string) (interface{}, error) {
+	lower := strings.ToLower(s)
+	for _, y := range yesAnswers {
+		if lower == y {
+			return true, nil
+		}
+	}
+	for _, n := range noAnswers {
+		if lower == n {
+			return false, nil
+		}
+	}
+	return nil, errors.New("please answer yes or no")
+}
+
+// parseRangeAnswer accepts plain numbers ("7"), number words ("seven", "fifty
+// five") and fractions ("7/10", "seven out of ten"). Fractions out of anything
+// but the top of the range are scaled onto it so "1/2" for a 0 to 10 range is
+// 5, while "7/10" is 7.
+func (a answerType) parseRangeAnswer(s string) (interface{}, error) {
+	lower, upper := a.parseRange()
+	rePrompt := fmt.Errorf("please answer with a number from %v to %v", lower, upper)
+
+	parts := strings.Split(strings.Replace(strings.ToLower(s), " out of ", "/", 1), "/")
+	if len(parts) > 2 {
+		return nil, rePrompt
+	}
+	f, ok := parseNumber(parts[0])
+	if !ok {
+		return nil, rePrompt
+	}
+	if len(parts) == 2 {
+		denominator, ok := parseNumber(parts[1])
+		if !ok || denominator <= 0 || f > denominator {
+			return nil, rePrompt
+		}
+		if denominator != upper {
+			f = lower + f/denominator*(upper-lower)
+		}
+	}
+	if f < lower || f > upper {
+		return nil, fmt.Errorf("%v is out of range, %v", f, rePrompt)
+	}
+	return f, nil
+}
+
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		// ParseFloat accepts "NaN" and "Inf" which aren't answers
+		return f, !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	if f, ok := numberWords[s]; ok {
+		return f, true
+	}
+	// compound numbers such as "fifty five" or "fifty-five"
+	words := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == '-' })
+	if len(words) != 2 {
+		return 0, false
+	}
+	tens, ok := numberWords[words[0]]
+	if !ok || tens < 20 || tens > 90 || math.Mod(tens, 10) != 0 {
+		return 0, false
+	}
+	units, ok := numberWords[words[1]]
+	if !ok || units < 1 || units > 9 {
+		return 0, false
+	}
+	return tens + units, true
+}
+
+func trimPunctuation(s string) string {
+	return strings.TrimSpace(strings.TrimRight(s, ".!?"))
+}
@@ -0,0 +1,53 @@
+package okr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAnswer(t *testing.T) {
+	tests := []struct {
+		reply      string
+		answerType answerType
+		answer     interface{}
+		err        string
+	}{
+		{"yes", BoolAnswerType(), true, ""},
+		{"Y", BoolAnswerType(), true, ""},
+		{" Yep! ", BoolAnswerType(), true, ""},
+		{"nope", BoolAnswerType(), false, ""},
+		{"No.", BoolAnswerType(), false, ""},
+		{"maybe", BoolAnswerType(), nil, "please answer yes or no"},
+		{"7", RangeAnswerType(0, 10), float64(7), ""},
+		{"7.5", RangeAnswerType(0, 10), float64(7.5), ""},
+		{"7/10", RangeAnswerType(0, 10), float64(7), ""},
+		{"seven", RangeAnswerType(0, 10), float64(7), ""},
+		{"Seven out of ten", RangeAnswerType(0, 10), float64(7), ""},
+		{"1/2", RangeAnswerType(0, 10), float64(5), ""},
+		{"3/4", RangeAnswerType(1, 5), float64(4), ""},
+		{"7/10", RangeAnswerType(1, 10), float64(7), ""},
+		{"1/2", RangeAnswerType(1, 10), float64(5.5), ""},
+		{"thirty", RangeAnswerType(0, 100), float64(30), ""},
+		{"Fifty five", RangeAnswerType(0, 100), float64(55), ""},
+		{"ninety-nine out of hundred", RangeAnswerType(0, 100), float64(99), ""},
+		{"twenty twenty", RangeAnswerType(0, 100), nil, "please answer with a number from 0 to 100"},
+		{"11", RangeAnswerType(0, 10), nil, "11 is out of range, please answer with a number from 0 to 10"},
+		{"lots", RangeAnswerType(0, 10), nil, "please answer with a number from 0 to 10"},
+		{"7/0", RangeAnswerType(0, 10), nil, "please answer with a number from 0 to 10"},
+		{"11/10", RangeAnswerType(0, 10), nil, "please answer with a number from 0 to 10"},
+		{"NaN", RangeAnswerType(0, 10), nil, "please answer with a number from 0 to 10"},
+		{"-Inf", RangeAnswerType(0, 10), nil, "please answer with a number from 0 to 10"},
+		{"1/inf", RangeAnswerType(0, 10), nil, "please answer with a number from 0 to 10"},
+		{"Shipped it!", TextAnswerType(), "Shipped it!", ""},
+		{"  ", TextAnswerType(), nil, "please reply with some text"},
+	}
+	for i, test := range tests {
+		answer, err := test.answerType.parseAnswer(test.reply)
+		errStr := ""
+		if err != nil {
+			errStr = err.Error()
+		}
+		assert(t, test.err == errStr, "test %v fail err (%v != %v)", i+1, errStr, test.err)
+		assert(t, reflect.DeepEqual(test.answer, answer), "test %v fail answer (%#v != %#v)", i+1, answer, test.answer)
+	}
+}
@@ -0,0 +1,161 @@
+package okr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func NewBoltRepo(b *bolt.DB) *BoltOKRRepo {
+	return &BoltOKRRepo{b}
+}
+
+type BoltOKRRepo struct {
+	*bolt.DB
+}
+
+var bucket = []byte("okrs")
+
+func (r *BoltOKRRepo) OKRForID(id string) (*OKR, error) {
+	var o *OKR
+	err := r.View(func(tx *bolt.Tx) error {
+		var err error
+		o, err = r.okrForID(tx, id)
+		return err
+	})
+	return o, err
+}
+
+func (r *BoltOKRRepo) OKRsForUser(userID string) ([]OKR, error) {
+	okrs, err := r.ListOKRs()
+	if err != nil {
+		return nil, err
+	}
+	userOKRs := make([]OKR, 0)
+	for _, o := range okrs {
+		if o.UserID == userID {
+			userOKRs = append(userOKRs, o)
+		}
+	}
+	return userOKRs, nil
+}
+
+func (r *BoltOKRRepo) ListOKRs() ([]OKR, error) {
+	okrs := make([]OKR, 0)
+	err := r.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k []byte, v []byte) error {
+			o, err := r.deserializeOKR(v)
+			if err != nil {
+				return err
+			}
+			okrs = append(okrs, *o)
+			return nil
+		})
+	})
+	return okrs, err
+}
+
+func (r *BoltOKRRepo) SaveOKR(o OKR) error {
+	return r.Update(func(tx *bolt.Tx) error {
+		return r.saveOKR(tx, o)
+	})
+}
+
+func (r *BoltOKRRepo) QuestionSpecsForOKR(okrID string) ([]QuestionSpec, error) {
+	o, err := r.OKRForID(okrID)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, fmt.Errorf("no okr with id %v", okrID)
+	}
+	return o.QuestionSpecs, nil
+}
+
+func (r *BoltOKRRepo) MarkAsked(okrID string, spec int, askAt time.Time, askedAt time.Time) error {
+	return r.updateQuestion(okrID, spec, askAt, func(qs *QuestionSpec, q *Question) error {
+		q.AskedAt = &askedAt
+		return nil
+	})
+}
+
+func (r *BoltOKRRepo) SaveAnswer(okrID string, spec int, askAt time.Time, answer interface{}, answeredAt time.Time) error {
+	return r.updateQuestion(okrID, spec, askAt, func(qs *QuestionSpec, q *Question) error {
+		if err := qs.checkAnswer(answer); err != nil {
+			return err
+		}
+		q.Answer = answer
+		q.AnsweredAt = &answeredAt
+		return nil
+	})
+}
+
+func (r *BoltOKRRepo) updateQuestion(okrID string, spec int, askAt time.Time, f func(qs *QuestionSpec, q *Question) error) error {
+	return r.Update(func(tx *bolt.Tx) error {
+		o, err := r.okrForID(tx, okrID)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			return fmt.Errorf("no okr with id %v", okrID)
+		}
+		if spec < 0 || spec >= len(o.QuestionSpecs) {
+			return fmt.Errorf("okr %v has no question spec %v", okrID, spec)
+		}
+		qs := &o.QuestionSpecs[spec]
+		q := qs.questionAt(askAt)
+		if q == nil {
+			return fmt.Errorf("no question to ask at %v", askAt)
+		}
+		if err := f(qs, q); err != nil {
+			return err
+		}
+		return r.saveOKR(tx, *o)
+	})
+}
+
+func (r *BoltOKRRepo) okrForID(tx *bolt.Tx, id string) (*OKR, error) {
+	bucket := tx.Bucket(bucket)
+	if bucket == nil {
+		return nil, nil
+	}
+	so := bucket.Get([]byte(id))
+	if len(so) == 0 {
+		return nil, nil
+	}
+	return r.deserializeOKR(so)
+}
+
+func (r *BoltOKRRepo) saveOKR(tx *bolt.Tx, o OKR) error {
+	if len(o.ID) == 0 {
+		return errors.New("okr id must be set to save okr")
+	}
+	so, err := r.serializeOKR(o)
+	if err != nil {
+		return err
+	}
+	bucket, err := tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(o.ID), so)
+}
+
+func (r *BoltOKRRepo) serializeOKR(o OKR) ([]byte, error) {
+	return json.Marshal(o)
+}
+
+func (r *BoltOKRRepo) deserializeOKR(b []byte) (*OKR, error) {
+	var o OKR
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
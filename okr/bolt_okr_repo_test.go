@@ -0,0 +1,82 @@
+package okr
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func tempBoltRepo(t *testing.T) (*BoltOKRRepo, func()) {
+	f, err := ioutil.TempFile("", "okr")
+	ok(t, err)
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	ok(t, err)
+	return NewBoltRepo(db), func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestBoltRepoSaveAndLoad(t *testing.T) {
+	r, cleanup := tempBoltRepo(t)
+	defer cleanup()
+
+	o, err := r.OKRForID("1")
+	ok(t, err)
+	assert(t, o == nil, "expected no okr before save")
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"Did you have a fun month?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{Answer: "", AskAt: time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)}}, BoolAnswerType()}
+
+	ok(t, r.SaveOKR(OKR{Title: "Fun", UserID: "batman", ID: "1", QuestionSpecs: []QuestionSpec{spec}}))
+	ok(t, r.SaveOKR(OKR{Title: "Sleep", UserID: "robin", ID: "2"}))
+	assert(t, r.SaveOKR(OKR{Title: "No ID"}) != nil, "expected error saving okr without id")
+
+	okrs, err := r.ListOKRs()
+	ok(t, err)
+	equals(t, 2, len(okrs))
+
+	okrs, err = r.OKRsForUser("batman")
+	ok(t, err)
+	equals(t, 1, len(okrs))
+	equals(t, "Fun", okrs[0].Title)
+
+	specs, err := r.QuestionSpecsForOKR("1")
+	ok(t, err)
+	equals(t, 1, len(specs))
+	equals(t, spec.Question, specs[0].Question)
+	equals(t, spec.AnswerType, specs[0].AnswerType)
+}
+
+func TestBoltRepoRecordsAskedAndAnswered(t *testing.T) {
+	r, cleanup := tempBoltRepo(t)
+	defer cleanup()
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	askAt := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"How fun was this month?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{Answer: "", AskAt: askAt}}, RangeAnswerType(0, 10)}
+	ok(t, r.SaveOKR(OKR{Title: "Fun", UserID: "batman", ID: "1", QuestionSpecs: []QuestionSpec{spec}}))
+
+	askedAt := askAt.Add(time.Minute)
+	ok(t, r.MarkAsked("1", 0, askAt, askedAt))
+
+	equals(t, "answer must be equal to or between 0 and 10", r.SaveAnswer("1", 0, askAt, float64(11), askedAt).Error())
+	assert(t, r.MarkAsked("1", 1, askAt, askedAt) != nil, "expected error for missing spec")
+	assert(t, r.MarkAsked("1", 0, askedAt, askedAt) != nil, "expected error for missing question")
+
+	answeredAt := askAt.Add(time.Hour)
+	ok(t, r.SaveAnswer("1", 0, askAt, float64(7), answeredAt))
+
+	o, err := r.OKRForID("1")
+	ok(t, err)
+	q := o.QuestionSpecs[0].Questions[0]
+	assert(t, q.AskedAt != nil && q.AskedAt.Equal(askedAt), "asked at not recorded %v", q.AskedAt)
+	assert(t, q.AnsweredAt != nil && q.AnsweredAt.Equal(answeredAt), "answered at not recorded %v", q.AnsweredAt)
+	equals(t, float64(7), q.Answer)
+}
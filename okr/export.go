@@ -0,0 +1,193 @@
+package okr
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/chat"
+)
+
+const (
+	_EXPORT_OKRS_MSG      = "export okrs"
+	_MAX_EXPORT_CHAT_ROWS = 50
+)
+
+var csvHeader = []string{"user", "okr", "question", "ask_at", "asked_at", "answered_at", "answer"}
+
+// ExportRow is a single question of a question spec flattened with the OKR and
+// user it belongs to.
+type ExportRow struct {
+	UserID     string      `json:"user"`
+	OKR        string      `json:"okr"`
+	Question   string      `json:"question"`
+	AskAt      time.Time   `json:"ask_at"`
+	AskedAt    *time.Time  `json:"asked_at"`
+	AnsweredAt *time.Time  `json:"answered_at"`
+	Answer     interface{} `json:"answer"`
+}
+
+// ExportFilter limits the exported rows. Zero values match everything and To
+// is exclusive.
+type ExportFilter struct {
+	UserID string
+	From   time.Time
+	To     time.Time
+}
+
+func (f ExportFilter) matches(o OKR, q Question) bool {
+	if len(f.UserID) > 0 && f.UserID != o.UserID {
+		return false
+	}
+	if !f.From.IsZero() && q.AskAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !q.AskAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+func ExportRows(okrs []OKR, f ExportFilter) []ExportRow {
+	rows := make([]ExportRow, 0)
+	for _, o := range okrs {
+		for _, spec := range o.QuestionSpecs {
+			for _, q := range spec.Questions {
+				if !f.matches(o, q) {
+					continue
+				}
+				row := ExportRow{o.UserID, o.Title, spec.Question, q.AskAt, q.AskedAt, q.AnsweredAt, nil}
+				if q.AnsweredAt != nil {
+					row.Answer = q.Answer
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows
+}
+
+func WriteCSV(w io.Writer, rows []ExportRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		answer := ""
+		if r.Answer != nil {
+			answer = fmt.Sprintf("%v", r.Answer)
+		}
+		record := []string{r.UserID, r.OKR, r.Question, formatTime(&r.AskAt), formatTime(r.AskedAt), formatTime(r.AnsweredAt), answer}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WriteJSON(w io.Writer, rows []ExportRow) error {
+	return json.NewEncoder(w).Encode(rows)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// exportOKRs handles "export okrs [user] [from] [to]" from admins. Small
+// exports are sent as CSV in a PM, larger ones as a per user summary.
+func exportOKRs(b *bot.Bot, m chat.InMsg, args bot.Args) {
+	filterArgs := make([]string, 0, 3)
+	for _, name := range []string{"user", "from", "to"} {
+		if args.Has(name) {
+			filterArgs = append(filterArgs, args.String(name))
+		}
+	}
+	f, err := parseExportFilter(filterArgs)
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Sorry, %v. Usage: %v [user] [from YYYY-MM-DD] [to YYYY-MM-DD]", err, _EXPORT_OKRS_MSG))
+		return
+	}
+	okrs, err := repo.ListOKRs()
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to fetch OKRs due to error: %v", err))
+		return
+	}
+	rows := ExportRows(okrs, f)
+	if len(rows) == 0 {
+		b.ReplyPM(m, "No questions to export.")
+		return
+	}
+	if len(rows) > _MAX_EXPORT_CHAT_ROWS {
+		b.ReplyPM(m, exportSummary(rows))
+		return
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, rows); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to export OKRs due to error: %v", err))
+		return
+	}
+	b.ReplyPM(m, buf.String())
+}
+
+func parseExportFilter(args []string) (ExportFilter, error) {
+	f := ExportFilter{}
+	dates := make([]time.Time, 0, 2)
+	for i, arg := range args {
+		t, err := time.Parse(_DATE_FORMAT, arg)
+		if err == nil {
+			dates = append(dates, t)
+			continue
+		}
+		if i > 0 {
+			return f, fmt.Errorf("%v isn't a date like %v", arg, _DATE_FORMAT)
+		}
+		f.UserID = arg
+	}
+	if len(dates) > 2 {
+		return f, errors.New("too many dates")
+	}
+	if len(dates) > 0 {
+		f.From = dates[0]
+	}
+	if len(dates) > 1 {
+		// include the whole of the last day
+		f.To = dates[1].AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+func exportSummary(rows []ExportRow) string {
+	type count struct{ asked, answered int }
+	counts := make(map[string]*count, 0)
+	keys := make([]string, 0)
+	for _, r := range rows {
+		key := r.UserID + " - " + r.OKR
+		c, ok := counts[key]
+		if !ok {
+			c = &count{}
+			counts[key] = c
+			keys = append(keys, key)
+		}
+		if r.AskedAt != nil {
+			c.asked++
+		}
+		if r.AnsweredAt != nil {
+			c.answered++
+		}
+	}
+	lines := []string{fmt.Sprintf("%v questions is too many to send over chat. Narrow the export by user or date. Summary:", len(rows))}
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%v: %v asked, %v answered", key, counts[key].asked, counts[key].answered))
+	}
+	return strings.Join(lines, "\n")
+}
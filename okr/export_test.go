@@ -0,0 +1,79 @@
+package okr
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/user"
+)
+
+func exportOKRFixtures() []OKR {
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	feb28th := time.Date(2015, 2, 28, 9, 0, 0, 0, time.UTC)
+	fun := QuestionSpec{"How fun was this month?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{Answer: float64(7), AskAt: jan31st, AskedAt: ptrTime(jan31st), AnsweredAt: ptrTime(jan31st.Add(time.Hour))}, Question{Answer: "", AskAt: feb28th}}, RangeAnswerType(0, 10)}
+	ship := QuestionSpec{"Did you ship it?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{Answer: true, AskAt: jan31st, AskedAt: ptrTime(jan31st), AnsweredAt: ptrTime(jan31st)}}, BoolAnswerType()}
+	return []OKR{
+		OKR{Title: "Fun", UserID: "batman", ID: "1", QuestionSpecs: []QuestionSpec{fun}},
+		OKR{Title: "Ship", UserID: "robin", ID: "2", QuestionSpecs: []QuestionSpec{ship}},
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	ok(t, WriteCSV(&buf, ExportRows(exportOKRFixtures(), ExportFilter{})))
+	equals(t, `user,okr,question,ask_at,asked_at,answered_at,answer
+batman,Fun,How fun was this month?,2015-01-31T09:00:00Z,2015-01-31T09:00:00Z,2015-01-31T10:00:00Z,7
+batman,Fun,How fun was this month?,2015-02-28T09:00:00Z,,,
+robin,Ship,Did you ship it?,2015-01-31T09:00:00Z,2015-01-31T09:00:00Z,2015-01-31T09:00:00Z,true
+`, buf.String())
+}
+
+func TestExportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	f := ExportFilter{UserID: "batman", To: time.Date(2015, 2, 1, 0, 0, 0, 0, time.UTC)}
+	ok(t, WriteJSON(&buf, ExportRows(exportOKRFixtures(), f)))
+	equals(t, `[{"user":"batman","okr":"Fun","question":"How fun was this month?","ask_at":"2015-01-31T09:00:00Z","asked_at":"2015-01-31T09:00:00Z","answered_at":"2015-01-31T10:00:00Z","answer":7}]
+`, buf.String())
+}
+
+func TestParseExportFilter(t *testing.T) {
+	f, err := parseExportFilter([]string{"robin", "2015-01-01", "2015-01-31"})
+	ok(t, err)
+	equals(t, ExportFilter{"robin", time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, 2, 1, 0, 0, 0, 0, time.UTC)}, f)
+
+	f, err = parseExportFilter([]string{"2015-01-01"})
+	ok(t, err)
+	equals(t, ExportFilter{From: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)}, f)
+
+	_, err = parseExportFilter([]string{"robin", "batman"})
+	equals(t, "batman isn't a date like 2006-01-02", err.Error())
+
+	_, err = parseExportFilter([]string{"2015-01-01", "2015-01-02", "2015-01-03"})
+	equals(t, "too many dates", err.Error())
+}
+
+func TestExportCommandIsAdminOnly(t *testing.T) {
+	_, b, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+	b.AddRootHandler(NewRootHandler())
+	for _, o := range exportOKRFixtures() {
+		ok(t, r.SaveOKR(o))
+	}
+
+	c.ExpectPM(chat.OutMsg{To: "joker", Body: "Sorry, you're not allowed to export okrs."})
+	b.HandleMessage(chat.InMsg{From: "joker", Body: "export okrs"})
+	c.Check()
+
+	ok(t, user.NewBoltRepo(r.DB).SaveUser(user.User{ID: "alfred", Roles: []string{user.AdminRole}}))
+	c.ExpectPM(chat.OutMsg{To: "alfred", Body: `user,okr,question,ask_at,asked_at,answered_at,answer
+robin,Ship,Did you ship it?,2015-01-31T09:00:00Z,2015-01-31T09:00:00Z,2015-01-31T09:00:00Z,true
+`})
+	b.HandleMessage(chat.InMsg{From: "alfred", Body: "Export OKRs robin 2015-01-01 2015-01-31"})
+	c.ExpectPM(chat.OutMsg{To: "alfred", Body: "No questions to export."})
+	b.HandleMessage(chat.InMsg{From: "alfred", Body: "export okrs robin 2015-02-01"})
+	c.Check()
+}
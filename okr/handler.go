@@ -0,0 +1,41 @@
+package okr
+
+import (
+	"fmt"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/user"
+)
+
+const (
+	_ADD_OKR_MSG = "add okr"
+
+	ExportPermission = "okrs.export"
+)
+
+var routes = []bot.Route{
+	{Pattern: _ADD_OKR_MSG, Help: "Add an OKR question to be asked on a schedule", PMOnly: true, Handle: addOKR},
+	{Pattern: _EXPORT_OKRS_MSG + " [user] [from] [to]", Help: "Export OKR answers, dates are YYYY-MM-DD", Permission: ExportPermission, PMOnly: true, Handle: exportOKRs},
+}
+
+func NewRootHandler() bot.MessageHandler {
+	r := bot.NewRouter(user.Authorize)
+	for _, route := range routes {
+		if err := r.AddRoute(route); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+func addOKR(b *bot.Bot, m chat.InMsg, args bot.Args) {
+	id, err := user.IDFor(m)
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to add OKR due to error: %v", err))
+		return
+	}
+	a := &addOKRHandler{msg: m, userID: id}
+	b.PushHandlerFor(m, a, nil)
+	a.next(b)
+}
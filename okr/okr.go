@@ -11,6 +11,19 @@ import (
 )
 
 type Repo interface {
+	OKRForID(id string) (*OKR, error)
+	OKRsForUser(userID string) ([]OKR, error)
+	ListOKRs() ([]OKR, error)
+	SaveOKR(o OKR) error
+	QuestionSpecsForOKR(okrID string) ([]QuestionSpec, error)
+	MarkAsked(okrID string, spec int, askAt time.Time, askedAt time.Time) error
+	SaveAnswer(okrID string, spec int, askAt time.Time, answer interface{}, answeredAt time.Time) error
+}
+
+var repo Repo
+
+func SetRepo(r Repo) {
+	repo = r
 }
 
 var now = func() time.Time {
@@ -49,6 +62,19 @@ func (a answerType) isBoolAnswer() bool {
 	return a == BoolAnswerType()
 }
 
+func (a answerType) IsText() bool {
+	return a.isTextAnswer()
+}
+
+func (a answerType) IsRange() bool {
+	return a.isRangeAnswer()
+}
+
+// Range returns the lower and upper bounds of a range answer type.
+func (a answerType) Range() (float64, float64) {
+	return a.parseRange()
+}
+
 func (r answerType) parseRange() (float64, float64) {
 	str := strings.Split(strings.TrimRight(strings.TrimLeft(string(r), "range["), "]"), ":")
 	lower, err := strconv.ParseFloat(str[0], 64)
@@ -73,6 +99,25 @@ type QuestionSpec struct {
 	AnswerType answerType
 }
 
+// NewQuestionSpec returns a spec with no generated questions after checking
+// that the schedule is a valid cron expression and the question only uses
+// known tokens.
+func NewQuestionSpec(question string, schedule string, starts time.Time, ends time.Time, a answerType) (*QuestionSpec, error) {
+	if len(strings.TrimSpace(question)) == 0 {
+		return nil, errors.New("question must not be empty")
+	}
+	if _, err := cronexpr.Parse(schedule); err != nil {
+		return nil, err
+	}
+	if !ends.After(starts) {
+		return nil, errors.New("end date must be after the start date")
+	}
+	if err := checkTokens(question); err != nil {
+		return nil, err
+	}
+	return &QuestionSpec{question, schedule, starts, ends, nil, a}, nil
+}
+
 type Question struct {
 	Answer     interface{}
 	AskAt      time.Time
@@ -107,6 +152,15 @@ func (qs QuestionSpec) checkAnswer(a interface{}) error {
 	return nil
 }
 
+func (s *QuestionSpec) questionAt(askAt time.Time) *Question {
+	for i := range s.Questions {
+		if s.Questions[i].AskAt.Equal(askAt) {
+			return &s.Questions[i]
+		}
+	}
+	return nil
+}
+
 func (s *QuestionSpec) removeUnaskedQuestions() {
 	qs := make([]Question, 0)
 	for _, q := range s.Questions {
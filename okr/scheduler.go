@@ -0,0 +1,208 @@
+package okr
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/cmd"
+	"github.com/mackross/go-bot/user"
+)
+
+const _THANKS_MSG = "Thanks, got it."
+
+// Scheduler periodically walks every OKR in the repo and PMs the owning user
+// any questions that are due. Only one question is outstanding per user at a
+// time so replies can't be confused between questions. Both the okr and user
+// repos must be set before the scheduler is started.
+type Scheduler struct {
+	sync.Mutex
+	bot     *bot.Bot
+	pending map[string]*answerHandler
+	stop    chan bool
+}
+
+func NewScheduler(b *bot.Bot) *Scheduler {
+	return &Scheduler{sync.Mutex{}, b, make(map[string]*answerHandler, 0), nil}
+}
+
+func (s *Scheduler) Start(interval time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan bool)
+	go func(stop chan bool) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Tick(); err != nil {
+					fmt.Println("Unable to ask okr questions:", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(s.stop)
+}
+
+func (s *Scheduler) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// Tick asks the next due question of every user that isn't already answering
+// one. Questions that were asked but never answered (e.g. the bot restarted
+// before the user replied) are asked again first. An OKR that can't be asked
+// about is logged so it doesn't hold up everyone else's.
+func (s *Scheduler) Tick() error {
+	okrs, err := repo.ListOKRs()
+	if err != nil {
+		return err
+	}
+	for _, o := range okrs {
+		if s.isPending(o.UserID) {
+			continue
+		}
+		if err := s.tickOKR(o); err != nil {
+			fmt.Printf("Unable to ask questions for okr %v: %v\n", o.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) tickOKR(o OKR) error {
+	generated, err := o.generateMissingQuestions()
+	if err != nil {
+		return err
+	}
+	if generated {
+		if err := repo.SaveOKR(o); err != nil {
+			return err
+		}
+	}
+	if spec, q := o.nextQuestion(); q != nil {
+		return s.ask(o, spec, *q)
+	}
+	return nil
+}
+
+func (s *Scheduler) ask(o OKR, spec int, q Question) error {
+	u, err := user.UserForID(o.UserID)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		// the owner hasn't spoken to the bot yet so their ID is their chat ID
+		u = &user.User{ID: o.UserID}
+	}
+	chatID, ok := u.ChatID(u.Network)
+	if !ok {
+		return fmt.Errorf("%v has no chat id to ask questions on", u.ID)
+	}
+	if err := repo.MarkAsked(o.ID, spec, q.AskAt, now()); err != nil {
+		return err
+	}
+	if err := u.SendPM(s.bot, u.Network, o.renderQuestion(spec, q.AskAt, u)); err != nil {
+		return err
+	}
+	h := &answerHandler{s, o.ID, o.UserID, spec, q.AskAt, o.QuestionSpecs[spec].AnswerType}
+	s.Lock()
+	s.pending[o.UserID] = h
+	s.Unlock()
+	s.bot.PushHandlerFor(chat.InMsg{Network: u.Network, From: chatID}, h, nil)
+	return nil
+}
+
+func (s *Scheduler) isPending(userID string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.pending[userID]
+	return ok
+}
+
+// popped lets the user be asked again once h is popped, whether it was
+// answered, cancelled or timed out.
+func (s *Scheduler) popped(h *answerHandler) {
+	s.Lock()
+	defer s.Unlock()
+	if s.pending[h.userID] == h {
+		delete(s.pending, h.userID)
+	}
+}
+
+func (o *OKR) generateMissingQuestions() (bool, error) {
+	generated := false
+	for i := range o.QuestionSpecs {
+		spec := &o.QuestionSpecs[i]
+		if len(spec.Questions) > 0 {
+			continue
+		}
+		questions, err := spec.generateQuestions(now())
+		if err != nil {
+			return false, err
+		}
+		spec.Questions = questions
+		generated = generated || len(questions) > 0
+	}
+	return generated, nil
+}
+
+// nextQuestion returns the question that should be asked next and the index of
+// its spec, or nil when nothing is due.
+func (o *OKR) nextQuestion() (int, *Question) {
+	for i := range o.QuestionSpecs {
+		if qs := o.QuestionSpecs[i].unansweredButAskedQuestions(); len(qs) > 0 {
+			return i, qs[0]
+		}
+	}
+	for i := range o.QuestionSpecs {
+		if qs := o.QuestionSpecs[i].unaskedQuestionsBefore(now()); len(qs) > 0 {
+			return i, qs[0]
+		}
+	}
+	return 0, nil
+}
+
+type answerHandler struct {
+	scheduler  *Scheduler
+	okrID      string
+	userID     string
+	spec       int
+	askAt      time.Time
+	answerType answerType
+}
+
+func (a *answerHandler) HandleMessage(b *bot.Bot, m chat.InMsg) bool {
+	if !m.IsPM() {
+		return false
+	}
+	if id, err := user.IDFor(m); err != nil || id != a.userID {
+		return false
+	}
+	answer, err := a.answerType.parseAnswer(m.Body)
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Sorry, %v.", err))
+		return true
+	}
+	if err := repo.SaveAnswer(a.okrID, a.spec, a.askAt, answer, now()); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to save answer due to error: %v", err))
+		return true
+	}
+	b.PopHandler(a)
+	b.ReplyPM(m, _THANKS_MSG)
+	return true
+}
+
+func (a *answerHandler) Popped(b *bot.Bot, result cmd.Result) {
+	a.scheduler.popped(a)
+}
@@ -0,0 +1,176 @@
+package okr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/user"
+)
+
+func mockScheduler(t *testing.T) (*Scheduler, *bot.Bot, *bottest.Chat, *BoltOKRRepo, func()) {
+	c := bottest.NewChat(t)
+	b := bot.NewBot(c)
+	r, cleanup := tempBoltRepo(t)
+	SetRepo(r)
+	user.SetRepo(user.NewBoltRepo(r.DB))
+	return NewScheduler(b), b, c, r, func() {
+		b.Stop()
+		resetTime()
+		cleanup()
+	}
+}
+
+func TestSchedulerAsksDueQuestionsAndRecordsAnswer(t *testing.T) {
+	s, b, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	feb28th := time.Date(2015, 2, 28, 9, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"How fun was this month?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{Answer: "", AskAt: jan31st}, Question{Answer: "", AskAt: feb28th}}, RangeAnswerType(0, 10)}
+	ok(t, r.SaveOKR(OKR{Title: "Fun", UserID: "batman", ID: "1", QuestionSpecs: []QuestionSpec{spec}}))
+
+	setTime(jan31st.Add(-time.Hour))
+	ok(t, s.Tick())
+	c.Check()
+
+	setTime(jan31st.Add(time.Minute))
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "How fun was this month?"})
+	ok(t, s.Tick())
+	c.Check()
+
+	// still waiting on an answer so nothing is asked twice
+	ok(t, s.Tick())
+	c.Check()
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Sorry, 11 is out of range, please answer with a number from 0 to 10."})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "11"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: _THANKS_MSG})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "7"})
+	c.Check()
+
+	o, err := r.OKRForID("1")
+	ok(t, err)
+	q := o.QuestionSpecs[0].Questions[0]
+	assert(t, q.AskedAt != nil && q.AskedAt.Equal(jan31st.Add(time.Minute)), "asked at not recorded %v", q.AskedAt)
+	assert(t, q.AnsweredAt != nil && q.AnsweredAt.Equal(jan31st.Add(time.Minute)), "answered at not recorded %v", q.AnsweredAt)
+	equals(t, float64(7), q.Answer)
+	assert(t, o.QuestionSpecs[0].Questions[1].AskedAt == nil, "feb question should not be asked yet")
+}
+
+func TestSchedulerGeneratesQuestions(t *testing.T) {
+	s, _, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"Did you ship it?", "0 9 L * *", jan1st2015, april1st2015, nil, BoolAnswerType()}
+	ok(t, r.SaveOKR(OKR{Title: "Ship", UserID: "robin", ID: "2", QuestionSpecs: []QuestionSpec{spec}}))
+
+	setTime(time.Date(2015, 2, 2, 0, 0, 0, 0, time.UTC))
+	ok(t, s.Tick())
+	c.Check()
+
+	o, err := r.OKRForID("2")
+	ok(t, err)
+	equals(t, 2, len(o.QuestionSpecs[0].Questions))
+	equals(t, time.Date(2015, 2, 28, 9, 0, 0, 0, time.UTC), o.QuestionSpecs[0].Questions[0].AskAt.UTC())
+}
+
+func TestSchedulerReasksUnansweredQuestions(t *testing.T) {
+	s, b, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"Did you ship it?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{Answer: "", AskAt: jan31st, AskedAt: ptrTime(jan31st)}}, BoolAnswerType()}
+	ok(t, r.SaveOKR(OKR{Title: "Ship", UserID: "robin", ID: "2", QuestionSpecs: []QuestionSpec{spec}}))
+
+	setTime(jan31st.Add(time.Hour))
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Did you ship it?"})
+	ok(t, s.Tick())
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: _THANKS_MSG})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "Yep!"})
+	c.Check()
+
+	o, err := r.OKRForID("2")
+	ok(t, err)
+	equals(t, true, o.QuestionSpecs[0].Questions[0].Answer)
+}
+
+func TestSchedulerAsksUsersOnTheirNetwork(t *testing.T) {
+	s, b, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+	irc := bottest.NewChat(t)
+	ok(t, b.AttachNetwork("irc", irc))
+	ok(t, user.NewBoltRepo(r.DB).SaveUser(user.User{ID: "irc:bruce", Network: "irc", Identities: map[string]string{"irc": "bruce"}}))
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"Did you ship it?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{AskAt: jan31st}}, BoolAnswerType()}
+	ok(t, r.SaveOKR(OKR{Title: "Ship", UserID: "irc:bruce", ID: "1", QuestionSpecs: []QuestionSpec{spec}}))
+
+	setTime(jan31st.Add(time.Minute))
+	irc.ExpectPM(chat.OutMsg{To: "bruce", Body: "Did you ship it?"})
+	ok(t, s.Tick())
+
+	// someone with the same nick on the bot's own network isn't bruce
+	b.HandleMessage(chat.InMsg{From: "bruce", Body: "no"})
+	irc.ExpectPM(chat.OutMsg{To: "bruce", Body: _THANKS_MSG})
+	b.HandleMessage(chat.InMsg{Network: "irc", From: "bruce", Body: "yes"})
+	irc.Check()
+	c.Check()
+
+	o, err := r.OKRForID("1")
+	ok(t, err)
+	equals(t, true, o.QuestionSpecs[0].Questions[0].Answer)
+}
+
+func TestSchedulerAsksAgainAfterACancel(t *testing.T) {
+	s, b, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"Did you ship it?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{AskAt: jan31st}}, BoolAnswerType()}
+	ok(t, r.SaveOKR(OKR{Title: "Ship", UserID: "robin", ID: "2", QuestionSpecs: []QuestionSpec{spec}}))
+
+	setTime(jan31st.Add(time.Minute))
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Did you ship it?"})
+	ok(t, s.Tick())
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Cancelled."})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "cancel"})
+	c.Check()
+	assert(t, !s.isPending("robin"), "expected robin not to be pending after cancelling")
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Did you ship it?"})
+	ok(t, s.Tick())
+	c.Check()
+}
+
+func TestSchedulerCarriesOnPastUsersItCantReach(t *testing.T) {
+	s, _, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+	// irc isn't attached so bruce can't be sent anything
+	ok(t, user.NewBoltRepo(r.DB).SaveUser(user.User{ID: "irc:bruce", Network: "irc", Identities: map[string]string{"irc": "bruce"}}))
+
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	spec := QuestionSpec{"Did you ship it?", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{AskAt: jan31st}}, BoolAnswerType()}
+	ok(t, r.SaveOKR(OKR{Title: "Ship", UserID: "irc:bruce", ID: "1", QuestionSpecs: []QuestionSpec{spec}}))
+	ok(t, r.SaveOKR(OKR{Title: "Ship", UserID: "robin", ID: "2", QuestionSpecs: []QuestionSpec{spec}}))
+
+	setTime(jan31st.Add(time.Minute))
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Did you ship it?"})
+	ok(t, s.Tick())
+	c.Check()
+	assert(t, !s.isPending("irc:bruce"), "expected bruce not to be pending when the question wasn't sent")
+}
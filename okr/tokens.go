@@ -0,0 +1,91 @@
+package okr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mackross/go-bot/user"
+)
+
+var tokenRegexp = regexp.MustCompile(`{{\s*([a-zA-Z_]+)\s*}}`)
+
+const _LAST_ASKED_FORMAT = "Mon Jan 2"
+
+// tokens maps each replaceable token to the function that renders it. A nil
+// user or previous question is passed when there isn't one.
+var tokens = map[string]func(c tokenContext) string{
+	"name": func(c tokenContext) string {
+		if c.user != nil && len(c.user.Name) > 0 {
+			return c.user.Name
+		}
+		return c.okr.UserID
+	},
+	"title": func(c tokenContext) string {
+		return c.okr.Title
+	},
+	"last_asked": func(c tokenContext) string {
+		if c.previous == nil || c.previous.AskedAt == nil {
+			return c.spec.Starts.Format(_LAST_ASKED_FORMAT)
+		}
+		return c.previous.AskedAt.Format(_LAST_ASKED_FORMAT)
+	},
+	"last_answer": func(c tokenContext) string {
+		if c.previous == nil || c.previous.AnsweredAt == nil {
+			return "nothing"
+		}
+		if b, ok := c.previous.Answer.(bool); ok {
+			if b {
+				return "yes"
+			}
+			return "no"
+		}
+		return fmt.Sprintf("%v", c.previous.Answer)
+	},
+}
+
+type tokenContext struct {
+	okr      OKR
+	spec     QuestionSpec
+	user     *user.User
+	previous *Question
+}
+
+func checkTokens(question string) error {
+	for _, match := range tokenRegexp.FindAllStringSubmatch(question, -1) {
+		if _, ok := tokens[strings.ToLower(match[1])]; !ok {
+			return fmt.Errorf("unknown token %v in question", match[0])
+		}
+	}
+	return nil
+}
+
+// renderQuestion replaces the tokens in the question of the spec at index spec
+// for the question asked at askAt.
+func (o OKR) renderQuestion(spec int, askAt time.Time, u *user.User) string {
+	qs := o.QuestionSpecs[spec]
+	c := tokenContext{o, qs, u, qs.previousQuestion(askAt)}
+	return tokenRegexp.ReplaceAllStringFunc(qs.Question, func(token string) string {
+		name := strings.ToLower(tokenRegexp.FindStringSubmatch(token)[1])
+		if f, ok := tokens[name]; ok {
+			return f(c)
+		}
+		return token
+	})
+}
+
+// previousQuestion returns the most recently asked question before t.
+func (s *QuestionSpec) previousQuestion(t time.Time) *Question {
+	var prev *Question
+	for i := range s.Questions {
+		q := &s.Questions[i]
+		if q.AskedAt == nil || !q.AskAt.Before(t) {
+			continue
+		}
+		if prev == nil || q.AskedAt.After(*prev.AskedAt) {
+			prev = q
+		}
+	}
+	return prev
+}
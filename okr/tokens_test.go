@@ -0,0 +1,40 @@
+package okr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot/user"
+)
+
+func TestNewQuestionSpecRejectsUnknownTokens(t *testing.T) {
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := NewQuestionSpec("How many deploys did you do since {{last_asked}}, {{ name }}?", "0 9 * * 1-5", jan1st2015, april1st2015, RangeAnswerType(0, 20))
+	ok(t, err)
+
+	_, err = NewQuestionSpec("How many {{deploys}} did you do?", "0 9 * * 1-5", jan1st2015, april1st2015, RangeAnswerType(0, 20))
+	equals(t, "unknown token {{deploys}} in question", err.Error())
+
+	_, err = NewQuestionSpec("How many deploys?", "not cron", jan1st2015, april1st2015, RangeAnswerType(0, 20))
+	assert(t, err != nil, "expected invalid schedule error")
+
+	_, err = NewQuestionSpec("How many deploys?", "0 9 * * 1-5", april1st2015, jan1st2015, RangeAnswerType(0, 20))
+	equals(t, "end date must be after the start date", err.Error())
+}
+
+func TestRenderQuestion(t *testing.T) {
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	april1st2015 := time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)
+	jan31st := time.Date(2015, 1, 31, 9, 0, 0, 0, time.UTC)
+	feb28th := time.Date(2015, 2, 28, 9, 0, 0, 0, time.UTC)
+	mar31st := time.Date(2015, 3, 31, 9, 0, 0, 0, time.UTC)
+
+	spec := QuestionSpec{"{{title}}: did you ship it since {{last_asked}}, {{name}}? Last time you said {{last_answer}}.", "0 9 L * *", jan1st2015, april1st2015, []Question{Question{Answer: true, AskAt: jan31st, AskedAt: ptrTime(jan31st), AnsweredAt: ptrTime(jan31st)}, Question{Answer: "", AskAt: feb28th, AskedAt: ptrTime(feb28th)}, Question{Answer: "", AskAt: mar31st}}, BoolAnswerType()}
+	o := OKR{Title: "Ship", UserID: "robin", ID: "1", QuestionSpecs: []QuestionSpec{spec}}
+
+	equals(t, "Ship: did you ship it since Thu Jan 1, robin? Last time you said nothing.", o.renderQuestion(0, jan31st, nil))
+	equals(t, "Ship: did you ship it since Sat Jan 31, Robin? Last time you said yes.", o.renderQuestion(0, feb28th, &user.User{ID: "robin", Name: "Robin"}))
+	equals(t, "Ship: did you ship it since Sat Feb 28, Robin? Last time you said nothing.", o.renderQuestion(0, mar31st, &user.User{ID: "robin", Name: "Robin"}))
+}
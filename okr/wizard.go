@@ -0,0 +1,229 @@
+package okr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorhill/cronexpr"
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/cmd"
+	"github.com/mackross/go-bot/user"
+)
+
+const _DATE_FORMAT = "2006-01-02"
+
+type wizardStep struct {
+	prompt string
+	set    func(a *addOKRHandler, s string) error
+}
+
+var addOKRSteps = []wizardStep{
+	{
+		"What is the title of the OKR?",
+		func(a *addOKRHandler, s string) error {
+			if len(s) == 0 {
+				return errors.New("the title must not be empty")
+			}
+			a.title = s
+			return nil
+		},
+	},
+	{
+		"What question should I ask? You can use the tokens " + tokenNames() + ".",
+		func(a *addOKRHandler, s string) error {
+			if len(s) == 0 {
+				return errors.New("the question must not be empty")
+			}
+			if err := checkTokens(s); err != nil {
+				return err
+			}
+			a.question = s
+			return nil
+		},
+	},
+	{
+		"When should I ask it? Please give a cron schedule e.g. 0 9 * * 1-5 for 9am on weekdays.",
+		func(a *addOKRHandler, s string) error {
+			if _, err := cronexpr.Parse(s); err != nil {
+				return fmt.Errorf("%v isn't a valid cron schedule (%v)", s, err)
+			}
+			a.schedule = s
+			return nil
+		},
+	},
+	{
+		"When should I start asking? (YYYY-MM-DD or today)",
+		func(a *addOKRHandler, s string) error {
+			t, err := parseDate(s)
+			if err != nil {
+				return err
+			}
+			a.starts = t
+			return nil
+		},
+	},
+	{
+		"When should I stop asking? (YYYY-MM-DD)",
+		func(a *addOKRHandler, s string) error {
+			t, err := parseDate(s)
+			if err != nil {
+				return err
+			}
+			if !t.After(a.starts) {
+				return fmt.Errorf("the end date must be after %v", a.starts.Format(_DATE_FORMAT))
+			}
+			a.ends = t
+			return nil
+		},
+	},
+	{
+		"What type of answer do you expect? (yes/no, text or range <lower> <upper>)",
+		func(a *addOKRHandler, s string) error {
+			t, err := parseAnswerType(s)
+			if err != nil {
+				return err
+			}
+			a.answerType = t
+			return nil
+		},
+	},
+}
+
+// promptTTL is how long each step waits for a reply before the wizard gives up.
+var promptTTL = 30 * time.Minute
+
+// addOKRHandler walks the user through each of the addOKRSteps by pushing a
+// promptHandler child per step and saves the OKR once the last child pops.
+type addOKRHandler struct {
+	msg        chat.InMsg
+	userID     string
+	step       int
+	title      string
+	question   string
+	schedule   string
+	starts     time.Time
+	ends       time.Time
+	answerType answerType
+}
+
+func (a *addOKRHandler) HandleMessage(b *bot.Bot, m chat.InMsg) bool {
+	return false
+}
+
+func (a *addOKRHandler) ChildPopped(b *bot.Bot, child bot.MessageHandler, id int, result cmd.Result) {
+	switch result.Reason {
+	case cmd.Completed:
+		a.step++
+		a.next(b)
+	case cmd.TimedOut:
+		b.PopHandler(a)
+		b.ReplyPM(a.msg, "You didn't reply so I've stopped adding the OKR. Say \"add okr\" to start again.")
+	case cmd.Superseded:
+		// the wizard itself is being popped
+	default:
+		b.PopHandler(a)
+	}
+}
+
+func (a *addOKRHandler) next(b *bot.Bot) {
+	if a.step < len(addOKRSteps) {
+		step := addOKRSteps[a.step]
+		b.ReplyPM(a.msg, step.prompt)
+		p := &promptHandler{a.userID, step.prompt, func(s string) error {
+			return step.set(a, s)
+		}}
+		b.PushHandler(p, a)
+		b.ExpireHandler(p, promptTTL)
+		return
+	}
+	b.PopHandler(a)
+
+	spec, err := NewQuestionSpec(a.question, a.schedule, a.starts, a.ends, a.answerType)
+	if err != nil {
+		b.ReplyPM(a.msg, fmt.Sprintf("Unable to add OKR due to error: %v", err))
+		return
+	}
+	o := OKR{Title: a.title, UserID: a.userID, ID: fmt.Sprintf("%v-%v", a.userID, now().UnixNano()), QuestionSpecs: []QuestionSpec{*spec}}
+	if err := repo.SaveOKR(o); err != nil {
+		b.ReplyPM(a.msg, fmt.Sprintf("Unable to save OKR due to error: %v", err))
+		return
+	}
+	b.ReplyPM(a.msg, fmt.Sprintf("Added OKR %v. I'll ask \"%v\" on the schedule %v from %v until %v.", o.Title, spec.Question, spec.Schedule, spec.Starts.Format(_DATE_FORMAT), spec.Ends.Format(_DATE_FORMAT)))
+}
+
+// promptHandler waits for a PM from the user and pops itself once set accepts
+// the reply. Rejected replies are explained and the prompt repeated.
+type promptHandler struct {
+	userID string
+	prompt string
+	set    func(s string) error
+}
+
+func (p *promptHandler) HandleMessage(b *bot.Bot, m chat.InMsg) bool {
+	if !m.IsPM() {
+		return false
+	}
+	if id, err := user.IDFor(m); err != nil || id != p.userID {
+		return false
+	}
+	reply := strings.TrimSpace(m.Body)
+	if err := p.set(reply); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Sorry, %v. %v", err, p.prompt))
+		return true
+	}
+	b.CompleteHandler(p, reply)
+	return true
+}
+
+func parseDate(s string) (time.Time, error) {
+	if strings.ToLower(s) == "today" {
+		y, m, d := now().Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now().Location()), nil
+	}
+	t, err := time.Parse(_DATE_FORMAT, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v isn't a date like %v", s, _DATE_FORMAT)
+	}
+	return t, nil
+}
+
+func parseAnswerType(s string) (answerType, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 1 {
+		switch fields[0] {
+		case "yes/no", "bool", "boolean":
+			return BoolAnswerType(), nil
+		case "text":
+			return TextAnswerType(), nil
+		}
+	}
+	if len(fields) == 3 && fields[0] == "range" {
+		lower, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return "", fmt.Errorf("%v isn't a number", fields[1])
+		}
+		upper, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return "", fmt.Errorf("%v isn't a number", fields[2])
+		}
+		if lower >= upper {
+			return "", errors.New("the lower bound must be less than the upper bound")
+		}
+		return RangeAnswerType(lower, upper), nil
+	}
+	return "", fmt.Errorf("%v isn't an answer type I know", s)
+}
+
+func tokenNames() string {
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, "{{"+name+"}}")
+	}
+	sort.Strings(names)
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,86 @@
+package okr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+func TestAddOKRWizard(t *testing.T) {
+	_, b, c, r, cleanup := mockScheduler(t)
+	defer cleanup()
+	b.AddRootHandler(NewRootHandler())
+	setTime(time.Date(2015, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	pm := func(body string) {
+		b.HandleMessage(chat.InMsg{From: "batman", Body: body})
+	}
+	roomID := "some room"
+	b.HandleMessage(chat.InMsg{From: "batman", RoomID: &roomID, Body: "add okr"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "What is the title of the OKR?"})
+	pm("Add OKR")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "What question should I ask? You can use the tokens {{last_answer}}, {{last_asked}}, {{name}} and {{title}}."})
+	pm("Fight crime")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Sorry, unknown token {{villains}} in question. What question should I ask? You can use the tokens {{last_answer}}, {{last_asked}}, {{name}} and {{title}}."})
+	pm("How many {{villains}} did you catch?")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "When should I ask it? Please give a cron schedule e.g. 0 9 * * 1-5 for 9am on weekdays."})
+	pm("How many villains did you catch since {{last_asked}}, {{name}}?")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "When should I start asking? (YYYY-MM-DD or today)"})
+	pm("0 9 * * 1-5")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "When should I stop asking? (YYYY-MM-DD)"})
+	pm("today")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Sorry, the end date must be after 2015-01-01. When should I stop asking? (YYYY-MM-DD)"})
+	pm("2014-12-31")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "What type of answer do you expect? (yes/no, text or range <lower> <upper>)"})
+	pm("2015-04-01")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Sorry, the lower bound must be less than the upper bound. What type of answer do you expect? (yes/no, text or range <lower> <upper>)"})
+	pm("range 10 0")
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Added OKR Fight crime. I'll ask \"How many villains did you catch since {{last_asked}}, {{name}}?\" on the schedule 0 9 * * 1-5 from 2015-01-01 until 2015-04-01."})
+	pm("range 0 10")
+	c.Check()
+
+	okrs, err := r.OKRsForUser("batman")
+	ok(t, err)
+	equals(t, 1, len(okrs))
+	equals(t, "Fight crime", okrs[0].Title)
+	spec := okrs[0].QuestionSpecs[0]
+	equals(t, "0 9 * * 1-5", spec.Schedule)
+	equals(t, RangeAnswerType(0, 10), spec.AnswerType)
+	equals(t, time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC), spec.Ends.UTC())
+
+	// the wizard is finished so further messages aren't captured
+	pm("Another message")
+	c.Check()
+}
+
+func TestAddOKRWizardGivesUpWithoutAReply(t *testing.T) {
+	_, b, c, _, cleanup := mockScheduler(t)
+	defer cleanup()
+	b.AddRootHandler(NewRootHandler())
+	promptTTL = -time.Second
+	defer func() { promptTTL = 30 * time.Minute }()
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "What is the title of the OKR?"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "add okr"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "You didn't reply so I've stopped adding the OKR. Say \"add okr\" to start again."})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "Fight crime"})
+	c.Check()
+}
+
+func TestParseAnswerType(t *testing.T) {
+	a, err := parseAnswerType("yes/no")
+	ok(t, err)
+	equals(t, BoolAnswerType(), a)
+	a, err = parseAnswerType("Text")
+	ok(t, err)
+	equals(t, TextAnswerType(), a)
+	a, err = parseAnswerType("range 1 5")
+	ok(t, err)
+	equals(t, RangeAnswerType(1, 5), a)
+	_, err = parseAnswerType("range one five")
+	equals(t, "one isn't a number", err.Error())
+	_, err = parseAnswerType("colour")
+	equals(t, "colour isn't an answer type I know", err.Error())
+}
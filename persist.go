@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mackross/go-bot/cmd"
+)
+
+// PersistentHandler is a pushed handler that survives the bot restarting.
+// Its kind must be registered with RegisterHandlerKind.
+type PersistentHandler interface {
+	MessageHandler
+	HandlerKind() string
+	HandlerState() ([]byte, error)
+}
+
+// NamedRoot is a root handler that persistent handlers pushed under it are
+// restored to after a restart. Its kind must be unique among the roots.
+type NamedRoot interface {
+	MessageHandler
+	HandlerKind() string
+}
+
+// HandlerRestorer recreates a handler from the state it saved.
+type HandlerRestorer func(b *Bot, state []byte) (MessageHandler, error)
+
+var (
+	handlerKinds   = make(map[string]HandlerRestorer, 0)
+	handlerKindsMu sync.RWMutex
+)
+
+// RegisterHandlerKind makes handlers of kind restorable. Modules register
+// their kinds in init, much like database/sql drivers.
+func RegisterHandlerKind(kind string, restore HandlerRestorer) {
+	handlerKindsMu.Lock()
+	defer handlerKindsMu.Unlock()
+	if _, exists := handlerKinds[kind]; exists {
+		panic("handler kind " + kind + " is already registered")
+	}
+	handlerKinds[kind] = restore
+}
+
+// Persist restores the handlers saved in store then saves pushed handlers to
+// it whenever they're pushed or popped, or a persistent handler handles a
+// message, so conversations carry on after a restart. Call it after adding
+// the root handlers. Handlers pushed under a root are only kept when the root
+// is a NamedRoot, as that's how they find it again.
+func (b *Bot) Persist(store cmd.Store) error {
+	saved, err := store.LoadStack()
+	if err != nil {
+		return err
+	}
+	restoreErr := b.cmdStack.Restore(saved, b.decodeHandler)
+	b.storeMu.Lock()
+	b.store = store
+	b.storeMu.Unlock()
+	b.cmdStack.OnChange(func(s *cmd.Stack) {
+		b.saveStack()
+	})
+	b.saveStack()
+	return restoreErr
+}
+
+func (b *Bot) saveStack() {
+	b.storeMu.Lock()
+	defer b.storeMu.Unlock()
+	if b.store == nil {
+		return
+	}
+	saved, err := b.cmdStack.Save(encodeHandler)
+	if err == nil {
+		err = b.store.SaveStack(saved)
+	}
+	if err != nil {
+		fmt.Println("Unable to save pushed handlers:", err)
+	}
+}
+
+func encodeHandler(c cmd.Command) (string, []byte, error) {
+	w, ok := c.(*commandWrapper)
+	if !ok {
+		return "", nil, nil
+	}
+	h, ok := w.handler.(PersistentHandler)
+	if !ok {
+		return "", nil, nil
+	}
+	state, err := h.HandlerState()
+	return h.HandlerKind(), state, err
+}
+
+func (b *Bot) decodeHandler(kind string, state []byte) (cmd.Command, error) {
+	handlerKindsMu.RLock()
+	restore, ok := handlerKinds[kind]
+	handlerKindsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no handler kind registered for %v", kind)
+	}
+	h, err := restore(b, state)
+	if err != nil {
+		return nil, err
+	}
+	return b.wrappedHandler(h), nil
+}
@@ -0,0 +1,60 @@
+package relay
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+func NewBoltRepo(b *bolt.DB) *BoltRelayRepo {
+	return &BoltRelayRepo{b}
+}
+
+type BoltRelayRepo struct {
+	*bolt.DB
+}
+
+var bucket = []byte("relays")
+
+func (r *BoltRelayRepo) ListBridges() ([]Bridge, error) {
+	bridges := make([]Bridge, 0)
+	err := r.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k []byte, v []byte) error {
+			var br Bridge
+			if err := json.Unmarshal(v, &br); err != nil {
+				return err
+			}
+			bridges = append(bridges, br)
+			return nil
+		})
+	})
+	return bridges, err
+}
+
+func (r *BoltRelayRepo) SaveBridge(br Bridge) error {
+	sbr, err := json.Marshal(br)
+	if err != nil {
+		return err
+	}
+	return r.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(br.Key()), sbr)
+	})
+}
+
+func (r *BoltRelayRepo) DeleteBridge(br Bridge) error {
+	return r.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(br.Key()))
+	})
+}
@@ -0,0 +1,303 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/user"
+)
+
+const (
+	_RELAY_MSG       = "relay"
+	_UNRELAY_MSG     = "unrelay"
+	_LIST_RELAYS_MSG = "list relays"
+
+	ManagePermission = "relays.manage"
+
+	// how many message IDs and relayed bodies are remembered for loop
+	// suppression
+	_MAX_SEEN = 1000
+)
+
+// Room is a room on one of the bot's networks written "network:room", or
+// just "room" for the bot's own network.
+type Room struct {
+	Network string
+	Name    string
+}
+
+func ParseRoom(s string) (Room, error) {
+	r := Room{Name: s}
+	if idx := strings.Index(s, ":"); idx != -1 {
+		r = Room{s[:idx], s[idx+1:]}
+	}
+	if len(r.Name) == 0 {
+		return r, fmt.Errorf("%v is missing a room name", s)
+	}
+	return r, nil
+}
+
+func (r Room) String() string {
+	if len(r.Network) == 0 {
+		return r.Name
+	}
+	return r.Network + ":" + r.Name
+}
+
+// Bridge mirrors messages both ways between two rooms.
+type Bridge struct {
+	A Room
+	B Room
+}
+
+func NewBridge(a, b Room) (Bridge, error) {
+	if a == b {
+		return Bridge{}, errors.New("Unable to relay a room to itself")
+	}
+	// stored in a canonical order so a->b and b->a are the same bridge
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return Bridge{a, b}, nil
+}
+
+func (br Bridge) Key() string {
+	return br.A.String() + " " + br.B.String()
+}
+
+func (br Bridge) String() string {
+	return br.A.String() + " and " + br.B.String()
+}
+
+func (br Bridge) other(r Room) (Room, bool) {
+	switch r {
+	case br.A:
+		return br.B, true
+	case br.B:
+		return br.A, true
+	}
+	return Room{}, false
+}
+
+type Repo interface {
+	ListBridges() ([]Bridge, error)
+	SaveBridge(br Bridge) error
+	DeleteBridge(br Bridge) error
+}
+
+var repo Repo
+
+func SetRepo(r Repo) {
+	repo = r
+}
+
+// JoinRooms joins every bridged room, usually called once the bot and its
+// networks have connected.
+func JoinRooms(b *bot.Bot) error {
+	bridges, err := repo.ListBridges()
+	if err != nil {
+		return err
+	}
+	for _, br := range bridges {
+		for _, r := range []Room{br.A, br.B} {
+			if err := joinRoom(b, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinRoom(b *bot.Bot, r Room) error {
+	n := b.NetworkNamed(r.Network)
+	if n == nil {
+		return fmt.Errorf("No network attached with name %v", r.Network)
+	}
+	return n.JoinRoom(r.Name)
+}
+
+var routes = []bot.Route{
+	{Pattern: _RELAY_MSG + " <room> <other-room>", Help: "Mirror messages between two rooms, written [network:]room", Permission: ManagePermission, PMOnly: true, Handle: addRelay},
+	{Pattern: _UNRELAY_MSG + " <room> <other-room>", Help: "Stop mirroring messages between two rooms", Permission: ManagePermission, PMOnly: true, Handle: removeRelay},
+	{Pattern: _LIST_RELAYS_MSG, Help: "List the rooms being mirrored", Permission: ManagePermission, PMOnly: true, Handle: listRelays},
+}
+
+type relayRootHandler struct {
+	*bot.Router
+	sync.Mutex
+	seen *recent
+	sent *recent
+}
+
+// recent is a set of strings that forgets the oldest once it holds _MAX_SEEN.
+type recent struct {
+	keys  map[string]bool
+	order []string
+}
+
+func newRecent() *recent {
+	return &recent{keys: make(map[string]bool, 0)}
+}
+
+// add records key and reports whether it wasn't already there.
+func (r *recent) add(key string) bool {
+	if r.keys[key] {
+		return false
+	}
+	r.keys[key] = true
+	r.order = append(r.order, key)
+	if len(r.order) > _MAX_SEEN {
+		delete(r.keys, r.order[0])
+		r.order = r.order[1:]
+	}
+	return true
+}
+
+func NewRootHandler() bot.MessageHandler {
+	r := bot.NewRouter(user.Authorize)
+	for _, route := range routes {
+		if err := r.AddRoute(route); err != nil {
+			panic(err)
+		}
+	}
+	return &relayRootHandler{Router: r, seen: newRecent(), sent: newRecent()}
+}
+
+func (r *relayRootHandler) HandleMessage(b *bot.Bot, m chat.InMsg) bool {
+	if !m.IsPM() {
+		// relaying never stops other handlers seeing the message
+		r.relay(b, m)
+		return false
+	}
+	return r.Router.HandleMessage(b, m)
+}
+
+// relay mirrors m to every room bridged with the room it was said in.
+func (r *relayRootHandler) relay(b *bot.Bot, m chat.InMsg) {
+	n := b.NetworkNamed(m.Network)
+	if n == nil || m.From == n.NickName() || r.isEcho(m) || !r.markSeen(m) {
+		return
+	}
+	bridges, err := repo.ListBridges()
+	if err != nil {
+		fmt.Println("Unable to fetch relays:", err)
+		return
+	}
+	from := Room{m.Network, *m.RoomID}
+	for _, br := range bridges {
+		to, ok := br.other(from)
+		if !ok {
+			continue
+		}
+		out := chat.OutMsg{To: to.Name, Body: fmt.Sprintf("<%v> %v", m.From, m.Body)}
+		if err := b.SendOn(to.Network, out); err != nil {
+			fmt.Printf("Unable to relay from %v to %v: %v\n", from, to, err)
+			continue
+		}
+		r.markSent(out.Body)
+	}
+}
+
+// markSeen records m and reports whether it hadn't been relayed before.
+// Networks can deliver the same message twice, for example when replaying
+// history after a reconnect.
+func (r *relayRootHandler) markSeen(m chat.InMsg) bool {
+	if len(m.ID) == 0 {
+		return true
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.seen.add(m.Network + "/" + m.ID)
+}
+
+func (r *relayRootHandler) markSent(body string) {
+	r.Lock()
+	defer r.Unlock()
+	r.sent.add(body)
+}
+
+// isEcho reports whether m carries a message this relay sent. Another relay
+// bridging the same rooms posts our messages back under its own nick, often
+// wrapped in its own "<nick>" prefix, with a new ID or none at all.
+func (r *relayRootHandler) isEcho(m chat.InMsg) bool {
+	r.Lock()
+	defer r.Unlock()
+	for _, body := range r.sent.order {
+		if strings.Contains(m.Body, body) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBridge(b *bot.Bot, a, c string) (Bridge, error) {
+	rooms := make([]Room, 0, 2)
+	for _, s := range []string{a, c} {
+		r, err := ParseRoom(s)
+		if err != nil {
+			return Bridge{}, err
+		}
+		if b.NetworkNamed(r.Network) == nil {
+			return Bridge{}, fmt.Errorf("No network attached with name %v", r.Network)
+		}
+		rooms = append(rooms, r)
+	}
+	return NewBridge(rooms[0], rooms[1])
+}
+
+func addRelay(b *bot.Bot, m chat.InMsg, args bot.Args) {
+	br, err := parseBridge(b, args.String("room"), args.String("other-room"))
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("%v. Usage: %v [network:]room [network:]room", err, _RELAY_MSG))
+		return
+	}
+	for _, r := range []Room{br.A, br.B} {
+		if err := joinRoom(b, r); err != nil {
+			b.ReplyPM(m, fmt.Sprintf("Unable to join %v due to error: %v", r, err))
+			return
+		}
+	}
+	if err := repo.SaveBridge(br); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to save relay due to error: %v", err))
+		return
+	}
+	user.Audit(m, _RELAY_MSG, br.Key(), "", "relaying")
+	b.ReplyPM(m, fmt.Sprintf("Relaying between %v.", br))
+}
+
+func removeRelay(b *bot.Bot, m chat.InMsg, args bot.Args) {
+	br, err := parseBridge(b, args.String("room"), args.String("other-room"))
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("%v. Usage: %v [network:]room [network:]room", err, _UNRELAY_MSG))
+		return
+	}
+	if err := repo.DeleteBridge(br); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to remove relay due to error: %v", err))
+		return
+	}
+	user.Audit(m, _UNRELAY_MSG, br.Key(), "relaying", "")
+	b.ReplyPM(m, fmt.Sprintf("No longer relaying between %v.", br))
+}
+
+func listRelays(b *bot.Bot, m chat.InMsg, args bot.Args) {
+	bridges, err := repo.ListBridges()
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to fetch relays due to error: %v", err))
+		return
+	}
+	if len(bridges) == 0 {
+		b.ReplyPM(m, "No relays configured.")
+		return
+	}
+	lines := make([]string, 0, len(bridges))
+	for _, br := range bridges {
+		lines = append(lines, br.A.String()+" <-> "+br.B.String())
+	}
+	sort.Strings(lines)
+	b.ReplyPM(m, strings.Join(lines, "\n"))
+}
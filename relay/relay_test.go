@@ -0,0 +1,165 @@
+package relay
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/user"
+)
+
+func mockRepo(t *testing.T) func() {
+	f, err := ioutil.TempFile("", "relay")
+	ok(t, err)
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	ok(t, err)
+	SetRepo(NewBoltRepo(db))
+	users := user.NewBoltRepo(db)
+	user.SetRepo(users)
+	ok(t, users.SaveUser(user.User{ID: "batman", Roles: []string{user.AdminRole}}))
+	return func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func mockRelay(t *testing.T) (*bot.Bot, *bottest.Chat, *bottest.Chat, func()) {
+	cleanup := mockRepo(t)
+	c := bottest.NewChat(t)
+	irc := bottest.NewChat(t)
+	b := bot.NewBot(c)
+	ok(t, b.AttachNetwork("irc", irc))
+	b.AddRootHandler(NewRootHandler())
+	return b, c, irc, cleanup
+}
+
+// roomChat records what is said in rooms so it can be handed to other bots.
+type roomChat struct {
+	network string
+	nick    string
+	said    []chat.InMsg
+}
+
+func (c *roomChat) Messages() <-chan chat.InMsg { return make(chan chat.InMsg) }
+func (c *roomChat) JoinRoom(s string) error     { return nil }
+func (c *roomChat) SetStatus(s string) error    { return nil }
+func (c *roomChat) OnConnect() <-chan bool      { return make(chan bool) }
+func (c *roomChat) NickName() string            { return c.nick }
+func (c *roomChat) SendPM(m chat.OutMsg) error  { return nil }
+func (c *roomChat) Send(m chat.OutMsg) error {
+	room := m.To
+	c.said = append(c.said, chat.InMsg{From: c.nick, Network: c.network, RoomID: &room, Body: m.Body})
+	return nil
+}
+
+func TestParseRoom(t *testing.T) {
+	r, err := ParseRoom("irc:#general")
+	ok(t, err)
+	equals(t, Room{"irc", "#general"}, r)
+	r, err = ParseRoom("general")
+	ok(t, err)
+	equals(t, Room{"", "general"}, r)
+	_, err = ParseRoom("irc:")
+	assert(t, err != nil, "expected error for missing room name")
+
+	br1, err := NewBridge(Room{"irc", "#general"}, Room{"", "general"})
+	ok(t, err)
+	br2, err := NewBridge(Room{"", "general"}, Room{"irc", "#general"})
+	ok(t, err)
+	equals(t, br1.Key(), br2.Key())
+	_, err = NewBridge(Room{"", "general"}, Room{"", "general"})
+	assert(t, err != nil, "expected error relaying room to itself")
+}
+
+func TestRelayMirrorsMessagesBothWays(t *testing.T) {
+	b, c, irc, cleanup := mockRelay(t)
+	defer cleanup()
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Relaying between general and irc:#general."})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "relay general irc:#general"})
+
+	general, ircGeneral, random := "general", "#general", "random"
+	irc.ExpectRoomMsg(chat.OutMsg{To: "#general", Body: "<alice> hi all"})
+	b.HandleMessage(chat.InMsg{ID: "1", From: "alice", RoomID: &general, Body: "hi all"})
+	// duplicates, the bot's own messages and unbridged rooms aren't relayed
+	b.HandleMessage(chat.InMsg{ID: "1", From: "alice", RoomID: &general, Body: "hi all"})
+	b.HandleMessage(chat.InMsg{ID: "2", From: "botty", Network: "irc", RoomID: &ircGeneral, Body: "<alice> hi all"})
+	b.HandleMessage(chat.InMsg{ID: "3", From: "alice", RoomID: &random, Body: "hello?"})
+
+	c.ExpectRoomMsg(chat.OutMsg{To: "general", Body: "<bob> hey alice"})
+	b.HandleMessage(chat.InMsg{ID: "1", From: "bob", Network: "irc", RoomID: &ircGeneral, Body: "hey alice"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "general <-> irc:#general"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "list relays"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "No longer relaying between general and irc:#general."})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "No relays configured."})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "unrelay irc:#general general"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "list relays"})
+	b.HandleMessage(chat.InMsg{ID: "4", From: "alice", RoomID: &general, Body: "anyone?"})
+
+	c.Check()
+	irc.Check()
+}
+
+func TestRelayCommandsAreValidated(t *testing.T) {
+	b, c, _, cleanup := mockRelay(t)
+	defer cleanup()
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Sorry, you're not allowed to relay."})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "relay general irc:#general"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "No network attached with name slack. Usage: relay [network:]room [network:]room"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "relay general slack:general"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Unable to relay a room to itself. Usage: relay [network:]room [network:]room"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "relay general general"})
+
+	c.Check()
+}
+
+func TestRelaysBridgingTheSameRoomsDontLoop(t *testing.T) {
+	cleanup := mockRepo(t)
+	defer cleanup()
+	br, err := NewBridge(Room{"", "general"}, Room{"irc", "#general"})
+	ok(t, err)
+	ok(t, repo.SaveBridge(br))
+
+	// two bots each relaying general <-> irc:#general
+	bots := make([]*bot.Bot, 0, 2)
+	chats := make([]*roomChat, 0, 4)
+	for _, nick := range []string{"botty", "otto"} {
+		c := &roomChat{nick: nick}
+		irc := &roomChat{network: "irc", nick: nick}
+		b := bot.NewBot(c)
+		ok(t, b.AttachNetwork("irc", irc))
+		b.AddRootHandler(NewRootHandler())
+		bots = append(bots, b)
+		chats = append(chats, c, irc)
+	}
+
+	general := "general"
+	pending := []chat.InMsg{{From: "alice", RoomID: &general, Body: "hi all"}}
+	relayed := 0
+	for round := 0; len(pending) > 0; round++ {
+		assert(t, round < 5, "relays are still looping: %+v", pending)
+		for _, m := range pending {
+			for _, b := range bots {
+				b.HandleMessage(m)
+			}
+		}
+		pending = nil
+		for _, c := range chats {
+			pending = append(pending, c.said...)
+			c.said = nil
+		}
+		relayed += len(pending)
+	}
+	// each bot relays alice once and drops the other's copy
+	equals(t, 2, relayed)
+}
@@ -0,0 +1,304 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+// Authorizer reports whether the sender of m holds permission.
+type Authorizer func(m chat.InMsg, permission string) bool
+
+// RouteFunc handles a message that matched a route's pattern.
+type RouteFunc func(b *Bot, m chat.InMsg, args Args)
+
+// RouteErrFunc is a RouteFunc that reports failures by returning an error.
+// The sender is told something went wrong, as with HandleErrors.
+type RouteErrFunc func(b *Bot, m chat.InMsg, args Args) error
+
+// Route is a command such as "toggle flag <user> <flag>". Patterns are made of
+// literal words followed by parameters:
+//
+//	<name>      a required argument
+//	<name:int>  a required whole number
+//	[name]      an optional argument, only after required ones
+//	<name...>   the rest of the message, only last
+//
+// Arguments containing spaces may be quoted with " or '.
+type Route struct {
+	Pattern    string
+	Help       string
+	Permission string // empty when anyone may run the command
+	PMOnly     bool
+	Handle     RouteFunc
+	HandleErr  RouteErrFunc // set instead of Handle
+
+	words  []string
+	params []routeParam
+}
+
+type routeParam struct {
+	name     string
+	isInt    bool
+	optional bool
+	rest     bool
+}
+
+// Usage is the pattern of the route, shown when a command is used wrongly.
+func (r *Route) Usage() string {
+	return r.Pattern
+}
+
+func (r *Route) parse() error {
+	if (r.Handle == nil) == (r.HandleErr == nil) {
+		return fmt.Errorf("route %v must have one of Handle or HandleErr", r.Pattern)
+	}
+	for _, f := range strings.Fields(r.Pattern) {
+		if !strings.HasPrefix(f, "<") && !strings.HasPrefix(f, "[") {
+			if len(r.params) > 0 {
+				return fmt.Errorf("route %v has a literal after a parameter", r.Pattern)
+			}
+			r.words = append(r.words, strings.ToLower(f))
+			continue
+		}
+		p := routeParam{}
+		switch {
+		case strings.HasPrefix(f, "<") && strings.HasSuffix(f, ">"):
+			p.name = f[1 : len(f)-1]
+		case strings.HasPrefix(f, "[") && strings.HasSuffix(f, "]"):
+			p.name = f[1 : len(f)-1]
+			p.optional = true
+		default:
+			return fmt.Errorf("route %v has malformed parameter %v", r.Pattern, f)
+		}
+		if strings.HasSuffix(p.name, "...") {
+			p.name = strings.TrimSuffix(p.name, "...")
+			p.rest = true
+		}
+		if strings.HasSuffix(p.name, ":int") {
+			p.name = strings.TrimSuffix(p.name, ":int")
+			p.isInt = true
+		}
+		if len(r.params) > 0 {
+			last := r.params[len(r.params)-1]
+			if last.rest {
+				return fmt.Errorf("route %v has a parameter after %v...", r.Pattern, last.name)
+			}
+			if last.optional && !p.optional {
+				return fmt.Errorf("route %v has required parameter %v after an optional one", r.Pattern, p.name)
+			}
+		}
+		r.params = append(r.params, p)
+	}
+	if len(r.words) == 0 {
+		return fmt.Errorf("route %v must start with a word", r.Pattern)
+	}
+	return nil
+}
+
+// matchesWords reports whether the message starts with the route's words.
+func (r *Route) matchesWords(tokens []string) bool {
+	if len(tokens) < len(r.words) {
+		return false
+	}
+	for i, w := range r.words {
+		if strings.ToLower(tokens[i]) != w {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Route) parseArgs(tokens []string) (Args, error) {
+	tokens = tokens[len(r.words):]
+	args := make(Args, len(r.params))
+	for i, p := range r.params {
+		if i >= len(tokens) {
+			if p.optional {
+				continue
+			}
+			return nil, fmt.Errorf("Missing %v", p.name)
+		}
+		v := tokens[i]
+		if p.rest {
+			v = strings.Join(tokens[i:], " ")
+		}
+		if p.isInt {
+			if _, err := strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("%v must be a whole number", p.name)
+			}
+		}
+		args[p.name] = v
+	}
+	if len(tokens) > len(r.params) && (len(r.params) == 0 || !r.params[len(r.params)-1].rest) {
+		return nil, errors.New("Too many arguments")
+	}
+	return args, nil
+}
+
+// Args holds the arguments of a matched route by parameter name.
+type Args map[string]string
+
+func (a Args) String(name string) string {
+	return a[name]
+}
+
+// Int returns a parameter declared as <name:int>, or 0 when it was omitted.
+func (a Args) Int(name string) int {
+	i, _ := strconv.Atoi(a[name])
+	return i
+}
+
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// Router is a MessageHandler that dispatches commands to registered routes.
+// When a command is recognised but its arguments are wrong the sender is sent
+// its usage, unless the message was said in a room.
+type Router struct {
+	routes    []*Route
+	authorize Authorizer
+}
+
+// NewRouter creates a router checking permissions with authorize, which may be
+// nil when no route requires a permission.
+func NewRouter(authorize Authorizer) *Router {
+	return &Router{make([]*Route, 0), authorize}
+}
+
+func (r *Router) AddRoute(route Route) error {
+	if err := route.parse(); err != nil {
+		return err
+	}
+	r.routes = append(r.routes, &route)
+	return nil
+}
+
+func (r *Router) Routes() []Route {
+	routes := make([]Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		routes = append(routes, *route)
+	}
+	return routes
+}
+
+// Allowed reports whether the sender of m may run route.
+func (r *Router) Allowed(m chat.InMsg, route Route) bool {
+	if len(route.Permission) == 0 {
+		return true
+	}
+	return r.authorize != nil && r.authorize(m, route.Permission)
+}
+
+func (r *Router) HandleMessage(b *Bot, m chat.InMsg) bool {
+	tokens, err := tokenize(m.Body)
+	if err != nil {
+		return false
+	}
+	route := r.match(m, tokens)
+	if route == nil {
+		return false
+	}
+	if !r.Allowed(m, *route) {
+		if m.IsPM() {
+			b.ReplyPM(m, fmt.Sprintf("Sorry, you're not allowed to %v.", strings.Join(route.words, " ")))
+		}
+		return m.IsPM()
+	}
+	args, err := route.parseArgs(tokens)
+	if err != nil {
+		// commands without parameters must be said exactly
+		if !m.IsPM() || len(route.params) == 0 {
+			return false
+		}
+		b.ReplyPM(m, fmt.Sprintf("%v. Usage: %v", err, route.Usage()))
+		return true
+	}
+	if route.HandleErr == nil {
+		route.Handle(b, m, args)
+	} else if err := route.HandleErr(b, m, args); err != nil {
+		b.reportError(m, err)
+	}
+	return true
+}
+
+// match finds the route with the most words the message starts with.
+func (r *Router) match(m chat.InMsg, tokens []string) *Route {
+	var best *Route
+	for _, route := range r.routes {
+		if route.PMOnly && !m.IsPM() {
+			continue
+		}
+		if route.matchesWords(tokens) && (best == nil || len(route.words) > len(best.words)) {
+			best = route
+		}
+	}
+	return best
+}
+
+// tokenize splits s on whitespace keeping quoted text together.
+func tokenize(s string) ([]string, error) {
+	tokens := make([]string, 0)
+	var current []rune
+	var quote rune
+	inToken := false
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current = append(current, c)
+		case (c == '"' || c == '\'') && !inToken:
+			// quotes only open at the start of a word so "don't" is left alone
+			quote = c
+			inToken = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inToken {
+				tokens = append(tokens, string(current))
+				current, inToken = nil, false
+			}
+		default:
+			current = append(current, c)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("Unterminated quote")
+	}
+	if inToken {
+		tokens = append(tokens, string(current))
+	}
+	return tokens, nil
+}
+
+// RequirePermission wraps h so it only sees messages from senders that
+// authorize grants permission, for handlers that don't use a Router.
+func RequirePermission(permission string, authorize Authorizer, h MessageHandler) MessageHandler {
+	return &permissionHandler{permission, authorize, h}
+}
+
+type permissionHandler struct {
+	permission string
+	authorize  Authorizer
+	handler    MessageHandler
+}
+
+func (p *permissionHandler) HandleMessage(b *Bot, m chat.InMsg) bool {
+	if !p.authorize(m, p.permission) {
+		return false
+	}
+	return p.handler.HandleMessage(b, m)
+}
+
+func (p *permissionHandler) Help(m chat.InMsg) []Route {
+	h, ok := p.handler.(HelpProvider)
+	if !ok || !p.authorize(m, p.permission) {
+		return nil
+	}
+	return h.Help(m)
+}
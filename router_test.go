@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize(`set title "Grow the team"  don't 'a b'`)
+	ok(t, err)
+	equals(t, []string{"set", "title", "Grow the team", "don't", "a b"}, tokens)
+	_, err = tokenize(`set title "Grow the team`)
+	assert(t, err != nil, "expected error for unterminated quote")
+}
+
+func TestRoutePatterns(t *testing.T) {
+	noop := func(b *Bot, m chat.InMsg, args Args) {}
+	r := NewRouter(nil)
+	ok(t, r.AddRoute(Route{Pattern: "toggle flag <user> <flag>", Handle: noop}))
+	ok(t, r.AddRoute(Route{Pattern: "repeat <times:int> [message...]", Handle: noop}))
+	assert(t, r.AddRoute(Route{Pattern: "<user> is", Handle: noop}) != nil, "expected error for pattern without words")
+	assert(t, r.AddRoute(Route{Pattern: "list [flag] <user>", Handle: noop}) != nil, "expected error for required after optional")
+	assert(t, r.AddRoute(Route{Pattern: "say <text...> <user>", Handle: noop}) != nil, "expected error for parameter after rest")
+	assert(t, r.AddRoute(Route{Pattern: "list users"}) != nil, "expected error for missing handler")
+	equals(t, 2, len(r.Routes()))
+}
+
+func TestRouterDispatchesArguments(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	var got Args
+	record := func(b *Bot, m chat.InMsg, args Args) { got = args }
+	isAdmin := func(m chat.InMsg, permission string) bool { return m.From == "batman" && permission == "admin" }
+	r := NewRouter(isAdmin)
+	ok(t, r.AddRoute(Route{Pattern: "toggle flag <user> <flag>", Permission: "admin", Handle: record}))
+	ok(t, r.AddRoute(Route{Pattern: "toggle <thing>", Handle: record}))
+	ok(t, r.AddRoute(Route{Pattern: "repeat <times:int> [message...]", Handle: record}))
+	ok(t, r.AddRoute(Route{Pattern: "hi", Handle: record}))
+	b.AddRootHandler(r)
+
+	b.HandleMessage(chat.InMsg{From: "batman", Body: `Toggle flag robin "night shift"`})
+	equals(t, Args{"user": "robin", "flag": "night shift"}, got)
+
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "toggle lights"})
+	equals(t, Args{"thing": "lights"}, got)
+
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "repeat 3 hip hip hooray"})
+	equals(t, 3, got.Int("times"))
+	equals(t, "hip hip hooray", got.String("message"))
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "repeat 3"})
+	assert(t, !got.Has("message"), "expected no message")
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Sorry, you're not allowed to toggle flag."})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Missing flag. Usage: toggle flag <user> <flag>"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Too many arguments. Usage: toggle flag <user> <flag>"})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "times must be a whole number. Usage: repeat <times:int> [message...]"})
+	got = nil
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "toggle flag robin admin"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "toggle flag robin"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "toggle flag robin a b"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "repeat thrice"})
+
+	// chatter in rooms and commands without parameters aren't corrected
+	room := "general"
+	b.HandleMessage(chat.InMsg{From: "batman", RoomID: &room, Body: "toggle flag robin"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "hi there"})
+	assert(t, got == nil, "expected no route to run, got %v", got)
+
+	c.Check()
+}
+
+func TestRouteErrorsAreReported(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	r := NewRouter(nil)
+	fail := func(b *Bot, m chat.InMsg, args Args) error { return errors.New("repo unavailable") }
+	succeed := func(b *Bot, m chat.InMsg, args Args) error { return nil }
+	ok(t, r.AddRoute(Route{Pattern: "fail", HandleErr: fail}))
+	ok(t, r.AddRoute(Route{Pattern: "succeed", HandleErr: succeed}))
+	assert(t, r.AddRoute(Route{Pattern: "both", Handle: func(b *Bot, m chat.InMsg, args Args) {}, HandleErr: succeed}) != nil, "expected error for two handlers")
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: _ERROR_MSG})
+	equals(t, true, r.HandleMessage(b, chat.InMsg{From: "robin", Body: "fail"}))
+	equals(t, true, r.HandleMessage(b, chat.InMsg{From: "robin", Body: "succeed"}))
+	c.Check()
+}
+
+func TestRequirePermission(t *testing.T) {
+	c := bottest.NewChat(t)
+	b := NewBot(c)
+	isAdmin := func(m chat.InMsg, permission string) bool { return m.From == "batman" && permission == "admin" }
+	b.AddRootHandler(RequirePermission("admin", isAdmin, NewGreeter("Towlie", "Howdy Ho!")))
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Howdy Ho!"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "Hello Towlie"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "Hello Towlie"})
+
+	c.Check()
+}
@@ -0,0 +1,531 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+
+	"github.com/gorilla/websocket"
+)
+
+const slackAPIURL = "https://slack.com/api/"
+
+// SlackNetwork is a chat.Network backed by the Slack RTM websocket for
+// incoming messages and the Web API for everything else. Rooms are addressed
+// by channel name and users by their Slack user name.
+type SlackNetwork struct {
+	sync.RWMutex
+	apiURL    string
+	token     string
+	http      *http.Client
+	botID     string
+	botName   string
+	rooms     map[string]string // channel id -> name
+	users     map[string]string // user id -> name
+	ims       map[string]string // user id -> im channel id
+	messages  chan chat.InMsg
+	onConnect chan bool
+	conn      *websocket.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// slackPage is embedded in the responses of methods that return their results
+// a page at a time.
+type slackPage struct {
+	Metadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+type slackEvent struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype"`
+	Channel string `json:"channel"`
+	User    string `json:"user"`
+	Text    string `json:"text"`
+	TS      string `json:"ts"`
+}
+
+func SlackConnect(token string) *SlackNetwork {
+	return slackConnect(slackAPIURL, token)
+}
+
+func slackConnect(apiURL string, token string) *SlackNetwork {
+	s := &SlackNetwork{
+		apiURL:    strings.TrimRight(apiURL, "/") + "/",
+		token:     token,
+		http:      &http.Client{Timeout: 10 * time.Second},
+		rooms:     make(map[string]string, 0),
+		users:     make(map[string]string, 0),
+		ims:       make(map[string]string, 0),
+		messages:  make(chan chat.InMsg, 0),
+		onConnect: make(chan bool, 1),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(s.messages)
+		for {
+			if err := s.connectAndRead(); err != nil && !s.closed() {
+				fmt.Println("Slack connection lost:", err)
+			}
+			if s.closed() {
+				return
+			}
+			fmt.Println("Retrying in 3 seconds")
+			select {
+			case <-time.After(3 * time.Second):
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Close disconnects from Slack and stops reconnecting. Messages is closed once
+// the connection is gone.
+func (s *SlackNetwork) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.Lock()
+		conn := s.conn
+		s.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+func (s *SlackNetwork) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *SlackNetwork) connectAndRead() error {
+	var rtm struct {
+		slackResponse
+		URL  string `json:"url"`
+		Self struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"self"`
+	}
+	if err := s.call("rtm.connect", url.Values{}, &rtm); err != nil {
+		return err
+	}
+	s.Lock()
+	s.botID, s.botName = rtm.Self.ID, rtm.Self.Name
+	s.Unlock()
+
+	conn, _, err := websocket.DefaultDialer.Dial(rtm.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.Lock()
+	s.conn = conn
+	s.Unlock()
+	// Close may have missed the connection while it was being made
+	if s.closed() {
+		return nil
+	}
+
+	for {
+		var e slackEvent
+		if err := conn.ReadJSON(&e); err != nil {
+			return err
+		}
+		switch e.Type {
+		case "hello":
+			fmt.Println("Connected")
+			select {
+			case s.onConnect <- true:
+			default:
+			}
+		case "message":
+			if m, ok := s.inMsg(e); ok {
+				select {
+				case s.messages <- m:
+				case <-s.done:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (s *SlackNetwork) inMsg(e slackEvent) (chat.InMsg, bool) {
+	start := time.Now()
+	s.RLock()
+	botID := s.botID
+	s.RUnlock()
+	// edits, joins and bot messages all arrive as subtypes of message
+	if len(e.Subtype) > 0 || len(e.User) == 0 || e.User == botID {
+		return chat.InMsg{}, false
+	}
+	from, err := s.userNameFromID(e.User)
+	if err != nil {
+		fmt.Println("Unable to process message:", err)
+		return chat.InMsg{}, false
+	}
+	m := chat.InMsg{ID: e.TS, From: from, Body: slackUnescaper.Replace(s.nameMentions(e.Text)), ArrivedAt: start}
+	if sec, err := strconv.ParseFloat(e.TS, 64); err == nil {
+		m.SentAt = time.Unix(0, int64(sec*float64(time.Second)))
+	}
+	// direct message channel ids start with a D
+	if !strings.HasPrefix(e.Channel, "D") {
+		roomName, err := s.roomNameFromID(e.Channel)
+		if err != nil {
+			fmt.Println("Unable to process message:", err)
+			return chat.InMsg{}, false
+		}
+		m.RoomID = &roomName
+	}
+	return m, true
+}
+
+// slackMentionRegexp matches user mentions such as <@U123> or <@U123|alice>.
+var slackMentionRegexp = regexp.MustCompile(`<@([UW][A-Z0-9]+)(\|[^>]*)?>`)
+
+// nameMentions replaces user mentions in text with @ and the user's name,
+// leaving those it can't find a name for as they are.
+func (s *SlackNetwork) nameMentions(text string) string {
+	return slackMentionRegexp.ReplaceAllStringFunc(text, func(mention string) string {
+		name, err := s.userNameFromID(slackMentionRegexp.FindStringSubmatch(mention)[1])
+		if err != nil {
+			return mention
+		}
+		return "@" + name
+	})
+}
+
+func (s *SlackNetwork) userNameFromID(id string) (string, error) {
+	s.RLock()
+	name, ok := s.users[id]
+	s.RUnlock()
+	if ok {
+		return name, nil
+	}
+	if err := s.refreshUsers(); err != nil {
+		return "", err
+	}
+	s.RLock()
+	name, ok = s.users[id]
+	s.RUnlock()
+	if ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("Unable to find user with id: %v", id)
+}
+
+func (s *SlackNetwork) roomNameFromID(id string) (string, error) {
+	s.RLock()
+	name, ok := s.rooms[id]
+	s.RUnlock()
+	if ok {
+		return name, nil
+	}
+	if err := s.refreshRooms(); err != nil {
+		return "", err
+	}
+	s.RLock()
+	name, ok = s.rooms[id]
+	s.RUnlock()
+	if ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("Unable to find room with id: %v", id)
+}
+
+func (s *SlackNetwork) idForName(names map[string]string, name string) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for id, n := range names {
+		if n == name {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (s *SlackNetwork) refreshUsers() error {
+	v := url.Values{}
+	for {
+		var list struct {
+			slackResponse
+			slackPage
+			Members []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"members"`
+		}
+		if err := s.call("users.list", v, &list); err != nil {
+			return err
+		}
+		s.Lock()
+		for _, u := range list.Members {
+			s.users[u.ID] = u.Name
+		}
+		s.Unlock()
+		if len(list.Metadata.NextCursor) == 0 {
+			return nil
+		}
+		v.Set("cursor", list.Metadata.NextCursor)
+	}
+}
+
+func (s *SlackNetwork) refreshRooms() error {
+	v := url.Values{"types": {"public_channel,private_channel"}}
+	for {
+		var list struct {
+			slackResponse
+			slackPage
+			Channels []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"channels"`
+		}
+		if err := s.call("conversations.list", v, &list); err != nil {
+			return err
+		}
+		s.Lock()
+		for _, c := range list.Channels {
+			s.rooms[c.ID] = c.Name
+		}
+		s.Unlock()
+		if len(list.Metadata.NextCursor) == 0 {
+			return nil
+		}
+		v.Set("cursor", list.Metadata.NextCursor)
+	}
+}
+
+func (s *SlackNetwork) roomID(name string) (string, error) {
+	if id, ok := s.idForName(s.rooms, name); ok {
+		return id, nil
+	}
+	if err := s.refreshRooms(); err != nil {
+		return "", err
+	}
+	if id, ok := s.idForName(s.rooms, name); ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("Unable to find room id with room name %v", name)
+}
+
+func (s *SlackNetwork) imID(name string) (string, error) {
+	userID, ok := s.idForName(s.users, name)
+	if !ok {
+		if err := s.refreshUsers(); err != nil {
+			return "", err
+		}
+		if userID, ok = s.idForName(s.users, name); !ok {
+			return "", fmt.Errorf("Unable to find user id with name %v", name)
+		}
+	}
+	s.RLock()
+	id, ok := s.ims[userID]
+	s.RUnlock()
+	if ok {
+		return id, nil
+	}
+	var open struct {
+		slackResponse
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	if err := s.call("conversations.open", url.Values{"users": {userID}}, &open); err != nil {
+		return "", err
+	}
+	s.Lock()
+	s.ims[userID] = open.Channel.ID
+	s.Unlock()
+	return open.Channel.ID, nil
+}
+
+func (s *SlackNetwork) NickName() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.botName
+}
+
+func (s *SlackNetwork) SendPM(m chat.OutMsg) error {
+	id, err := s.imID(m.To)
+	if err != nil {
+		return err
+	}
+	return s.post(id, m)
+}
+
+func (s *SlackNetwork) Send(m chat.OutMsg) error {
+	id, err := s.roomID(m.To)
+	if err != nil {
+		return err
+	}
+	return s.post(id, m)
+}
+
+// post sends a message with chat.postMessage. Plain bodies are escaped so
+// Slack doesn't read them as links or mentions, HTML bodies are converted to
+// Slack's mrkdwn in a section block and colored messages become an attachment.
+func (s *SlackNetwork) post(channelID string, m chat.OutMsg) error {
+	text := slackEscaper.Replace(m.Body)
+	v := url.Values{"channel": {channelID}, "text": {text}, "as_user": {"true"}}
+	if m.HTML != nil && *m.HTML {
+		text = htmlToMrkdwn(m.Body)
+		v.Set("text", text)
+		blocks, err := json.Marshal([]interface{}{
+			map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+		})
+		if err != nil {
+			return err
+		}
+		v.Set("blocks", string(blocks))
+	}
+	if m.Color != nil {
+		attachments, err := json.Marshal([]map[string]string{{"color": slackColor(*m.Color), "text": text, "fallback": text}})
+		if err != nil {
+			return err
+		}
+		v.Set("attachments", string(attachments))
+		v.Del("blocks")
+	}
+	return s.call("chat.postMessage", v, &slackResponse{})
+}
+
+func (s *SlackNetwork) JoinRoom(room string) error {
+	id, err := s.roomID(room)
+	if err != nil {
+		return err
+	}
+	return s.call("conversations.join", url.Values{"channel": {id}}, &slackResponse{})
+}
+
+func (s *SlackNetwork) SetStatus(status string) error {
+	profile, err := json.Marshal(map[string]string{"status_text": status})
+	if err != nil {
+		return err
+	}
+	return s.call("users.profile.set", url.Values{"profile": {string(profile)}}, &slackResponse{})
+}
+
+func (s *SlackNetwork) Messages() <-chan chat.InMsg {
+	return s.messages
+}
+
+func (s *SlackNetwork) OnConnect() <-chan bool {
+	return s.onConnect
+}
+
+// call posts a Web API method and decodes the response into v which must embed
+// slackResponse.
+func (s *SlackNetwork) call(method string, v url.Values, resp interface{ ok() error }) error {
+	req, err := http.NewRequest("POST", s.apiURL+method, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	r, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack %v returned %v", method, r.Status)
+	}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return err
+	}
+	return resp.ok()
+}
+
+func (r *slackResponse) ok() error {
+	if !r.OK {
+		if len(r.Error) == 0 {
+			return errors.New("slack request failed")
+		}
+		return errors.New("slack: " + r.Error)
+	}
+	return nil
+}
+
+var (
+	// only &, < and > need escaping in message text
+	slackEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	slackUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+
+	// slackColors maps HipChat's message colors to attachment colors
+	slackColors = map[string]string{
+		"yellow": "warning",
+		"green":  "good",
+		"red":    "danger",
+		"purple": "#8e44ad",
+		"gray":   "#95a5a6",
+	}
+)
+
+// slackColor returns the attachment color for a HipChat color name. Anything
+// else, such as a hex color, is passed through as is.
+func slackColor(color string) string {
+	if c, ok := slackColors[color]; ok {
+		return c
+	}
+	if color == "random" {
+		colors := make([]string, 0, len(slackColors))
+		for _, c := range slackColors {
+			colors = append(colors, c)
+		}
+		return colors[rand.Intn(len(colors))]
+	}
+	return color
+}
+
+var (
+	htmlLinkRegexp = regexp.MustCompile(`(?i)<a\s+[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlReplacer   = strings.NewReplacer(
+		"<b>", "*", "</b>", "*", "<strong>", "*", "</strong>", "*",
+		"<i>", "_", "</i>", "_", "<em>", "_", "</em>", "_",
+		"<code>", "`", "</code>", "`", "<pre>", "```", "</pre>", "```",
+		"<br>", "\n", "<br/>", "\n", "<br />", "\n",
+	)
+	htmlEntityReplacer = strings.NewReplacer("&nbsp;", " ", "&quot;", "\"", "&#39;", "'")
+)
+
+// htmlToMrkdwn converts the simple HTML used in HipChat notifications to Slack
+// mrkdwn. Tags without a mrkdwn equivalent are dropped. &amp;, &lt; and &gt;
+// are left escaped as Slack expects.
+func htmlToMrkdwn(html string) string {
+	s := htmlLinkRegexp.ReplaceAllString(html, "<$1|$2>")
+	s = htmlReplacer.Replace(s)
+	// keep the <url|text> links made above while stripping other tags
+	s = htmlTagRegexp.ReplaceAllStringFunc(s, func(tag string) string {
+		if strings.Contains(tag, "|") {
+			return tag
+		}
+		return ""
+	})
+	return htmlEntityReplacer.Replace(s)
+}
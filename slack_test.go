@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeSlack is a minimal Slack Web API and RTM server.
+type fakeSlack struct {
+	sync.Mutex
+	*httptest.Server
+	events chan interface{}
+	posts  []url.Values
+	calls  []string
+}
+
+func newFakeSlack() *fakeSlack {
+	f := &fakeSlack{events: make(chan interface{}, 10)}
+	mux := http.NewServeMux()
+	respond := func(w http.ResponseWriter, body string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			respond(w, `{"ok":false,"error":"invalid_auth"}`)
+			return
+		}
+		r.ParseForm()
+		method := strings.TrimPrefix(r.URL.Path, "/api/")
+		f.Lock()
+		f.calls = append(f.calls, method)
+		f.Unlock()
+		switch method {
+		case "rtm.connect":
+			respond(w, `{"ok":true,"url":"ws`+strings.TrimPrefix(f.URL, "http")+`/ws","self":{"id":"UBOT","name":"botty"}}`)
+		// lists come back a page at a time
+		case "users.list":
+			if r.Form.Get("cursor") == "" {
+				respond(w, `{"ok":true,"members":[{"id":"UBOT","name":"botty"},{"id":"U1","name":"alice"}],"response_metadata":{"next_cursor":"bob"}}`)
+			} else {
+				respond(w, `{"ok":true,"members":[{"id":"U2","name":"bob"}],"response_metadata":{"next_cursor":""}}`)
+			}
+		case "conversations.list":
+			if r.Form.Get("cursor") == "" {
+				respond(w, `{"ok":true,"channels":[{"id":"C1","name":"general"}],"response_metadata":{"next_cursor":"random"}}`)
+			} else {
+				respond(w, `{"ok":true,"channels":[{"id":"C2","name":"random"}]}`)
+			}
+		case "conversations.open":
+			respond(w, `{"ok":true,"channel":{"id":"D`+r.Form.Get("users")+`"}}`)
+		case "chat.postMessage":
+			f.Lock()
+			f.posts = append(f.posts, r.Form)
+			f.Unlock()
+			respond(w, `{"ok":true}`)
+		case "conversations.join", "users.profile.set":
+			respond(w, `{"ok":true}`)
+		default:
+			respond(w, `{"ok":false,"error":"unknown_method"}`)
+		}
+	})
+	upgrader := websocket.Upgrader{}
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(map[string]string{"type": "hello"})
+		for e := range f.events {
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeSlack) lastPost() url.Values {
+	f.Lock()
+	defer f.Unlock()
+	return f.posts[len(f.posts)-1]
+}
+
+func connectToFakeSlack(t *testing.T) (*SlackNetwork, *fakeSlack) {
+	f := newFakeSlack()
+	s := slackConnect(f.URL+"/api", "xoxb-test")
+	select {
+	case <-s.OnConnect():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out connecting to fake slack")
+	}
+	return s, f
+}
+
+func nextMessage(t *testing.T, s *SlackNetwork) chat.InMsg {
+	select {
+	case m := <-s.Messages():
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return chat.InMsg{}
+}
+
+func TestSlackReceivesRoomAndDirectMessages(t *testing.T) {
+	s, f := connectToFakeSlack(t)
+	defer f.Close()
+	equals(t, "botty", s.NickName())
+
+	f.events <- map[string]string{"type": "message", "channel": "C1", "user": "U1", "text": "hi all", "ts": "1420102800.000100"}
+	m := nextMessage(t, s)
+	equals(t, "alice", m.From)
+	equals(t, "hi all", m.Body)
+	equals(t, "1420102800.000100", m.ID)
+	equals(t, "general", *m.RoomID)
+	equals(t, int64(1420102800), m.SentAt.Unix())
+
+	// our own messages and edits are ignored
+	f.events <- map[string]string{"type": "message", "channel": "C1", "user": "UBOT", "text": "echo", "ts": "2"}
+	f.events <- map[string]string{"type": "message", "subtype": "message_changed", "channel": "C1", "ts": "3"}
+	f.events <- map[string]string{"type": "message", "channel": "DU2", "user": "U2", "text": "whoami", "ts": "4"}
+	m = nextMessage(t, s)
+	equals(t, "bob", m.From)
+	assert(t, m.IsPM(), "expected direct message to be a PM")
+
+	f.events <- map[string]string{"type": "message", "channel": "C2", "user": "U1", "text": "a &lt; b &amp;&amp; b &gt; c", "ts": "5"}
+	m = nextMessage(t, s)
+	equals(t, "a < b && b > c", m.Body)
+	equals(t, "random", *m.RoomID)
+
+	f.events <- map[string]string{"type": "message", "channel": "C1", "user": "U1", "text": "<@U2> meet <@UBOT|botty>, not <@U404>", "ts": "6"}
+	m = nextMessage(t, s)
+	equals(t, "@bob meet @botty, not <@U404>", m.Body)
+}
+
+func TestSlackCloseStopsReading(t *testing.T) {
+	s, f := connectToFakeSlack(t)
+	defer f.Close()
+
+	ok(t, s.Close())
+	ok(t, s.Close())
+	select {
+	case _, open := <-s.Messages():
+		assert(t, !open, "expected no messages after closing")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for messages to close")
+	}
+}
+
+func TestSlackSendsMessages(t *testing.T) {
+	s, f := connectToFakeSlack(t)
+	defer f.Close()
+
+	ok(t, s.Send(chat.OutMsg{To: "random", Body: "hello"}))
+	equals(t, "C2", f.lastPost().Get("channel"))
+	equals(t, "hello", f.lastPost().Get("text"))
+
+	ok(t, s.Send(chat.OutMsg{To: "random", Body: "<b> & <!channel>"}))
+	equals(t, "&lt;b&gt; &amp; &lt;!channel&gt;", f.lastPost().Get("text"))
+
+	ok(t, s.SendPM(chat.OutMsg{To: "alice", Body: "psst"}))
+	equals(t, "DU1", f.lastPost().Get("channel"))
+
+	html := true
+	ok(t, s.Send(chat.OutMsg{To: "general", Body: `<b>Build</b> passed, see <a href="http://ci">ci</a>`, HTML: &html}))
+	equals(t, "*Build* passed, see <http://ci|ci>", f.lastPost().Get("text"))
+	assert(t, strings.Contains(f.lastPost().Get("blocks"), `"type":"mrkdwn"`), "expected mrkdwn block %v", f.lastPost())
+
+	red := "red"
+	ok(t, s.Send(chat.OutMsg{To: "general", Body: "failed", Color: &red}))
+	equals(t, `[{"color":"danger","fallback":"failed","text":"failed"}]`, f.lastPost().Get("attachments"))
+	hex := "#ff00ff"
+	ok(t, s.Send(chat.OutMsg{To: "general", Body: "custom", Color: &hex}))
+	equals(t, `[{"color":"#ff00ff","fallback":"custom","text":"custom"}]`, f.lastPost().Get("attachments"))
+
+	assert(t, s.Send(chat.OutMsg{To: "nowhere", Body: "hello"}) != nil, "expected error sending to unknown room")
+	ok(t, s.JoinRoom("general"))
+	ok(t, s.SetStatus("away"))
+}
@@ -77,9 +77,81 @@ func (r *BoltUserRepo) serializeUser(u User) ([]byte, error) {
 }
 
 func (r *BoltUserRepo) deserializeUser(b []byte) (*User, error) {
-	var u User
+	// users saved before roles existed have IsAdmin instead of the admin role
+	var u struct {
+		User
+		IsAdmin bool
+	}
 	if err := json.Unmarshal(b, &u); err != nil {
 		return nil, err
 	}
-	return &u, nil
+	if u.IsAdmin {
+		u.grant(AdminRole)
+	}
+	return &u.User, nil
+}
+
+var rolesBucket = []byte("roles")
+
+func (r *BoltUserRepo) RoleForName(name string) (*Role, error) {
+	var role *Role
+	err := r.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(rolesBucket)
+		if bucket == nil {
+			return nil
+		}
+		sr := bucket.Get([]byte(name))
+		if len(sr) == 0 {
+			return nil
+		}
+		role = &Role{}
+		return json.Unmarshal(sr, role)
+	})
+	return role, err
+}
+
+func (r *BoltUserRepo) ListRoles() ([]Role, error) {
+	roles := make([]Role, 0)
+	err := r.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(rolesBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k []byte, v []byte) error {
+			var role Role
+			if err := json.Unmarshal(v, &role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (r *BoltUserRepo) SaveRole(role Role) error {
+	if len(role.Name) == 0 {
+		return errors.New("role name must be set to save role")
+	}
+	sr, err := json.Marshal(role)
+	if err != nil {
+		return err
+	}
+	return r.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(rolesBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(role.Name), sr)
+	})
+}
+
+func (r *BoltUserRepo) DeleteRole(name string) error {
+	return r.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(rolesBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(name))
+	})
 }
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func tempBoltRepo(t *testing.T) (*BoltUserRepo, func()) {
+	f, err := ioutil.TempFile("", "user")
+	ok(t, err)
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	ok(t, err)
+	return NewBoltRepo(db), func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestBoltRepoMigratesAdmins(t *testing.T) {
+	r, cleanup := tempBoltRepo(t)
+	defer cleanup()
+	ok(t, r.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("batman"), []byte(`{"ID":"batman","IsAdmin":true}`))
+	}))
+	u, err := r.UserForID("batman")
+	ok(t, err)
+	equals(t, []string{AdminRole}, u.Roles)
+}
+
+func TestBoltRepoSavesRoles(t *testing.T) {
+	r, cleanup := tempBoltRepo(t)
+	defer cleanup()
+
+	role, err := r.RoleForName("okr")
+	ok(t, err)
+	assert(t, role == nil, "expected no role before save")
+
+	ok(t, r.SaveRole(Role{"okr", []string{"okrs.export", "dashboard.all"}}))
+	role, err = r.RoleForName("okr")
+	ok(t, err)
+	equals(t, &Role{"okr", []string{"okrs.export", "dashboard.all"}}, role)
+	roles, err := r.ListRoles()
+	ok(t, err)
+	equals(t, 1, len(roles))
+
+	ok(t, r.DeleteRole("okr"))
+	roles, err = r.ListRoles()
+	ok(t, err)
+	equals(t, 0, len(roles))
+}
@@ -0,0 +1,87 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/chat"
+)
+
+// BootstrapTTL is how long the token printed at startup can be used to
+// become the first admin.
+var BootstrapTTL = 30 * time.Minute
+
+var now = time.Now
+
+// bootstrap holds the one-time token that makes whoever PMs "admin me <token>"
+// the first admin. Only someone who can read the bot's output has it, so a
+// fresh deployment can't be taken over by whoever messages it first.
+var bootstrap struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Bootstrap prints a new token for "admin me <token>" when there are no admins
+// yet. Call it at startup after SetRepo.
+func Bootstrap() error {
+	if repo == nil {
+		return errors.New("user repo must be set before bootstrapping")
+	}
+	if admins, err := Admins(); err != nil || len(admins) > 0 {
+		return err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	bootstrap.Lock()
+	defer bootstrap.Unlock()
+	bootstrap.token = hex.EncodeToString(b)
+	bootstrap.expires = now().Add(BootstrapTTL)
+	fmt.Printf("No admins yet. PM \"%v %v\" to the bot within %v to become one.\n", _BECOME_ADMIN_MSG, bootstrap.token, BootstrapTTL)
+	return nil
+}
+
+// useBootstrapToken reports whether token is the current unexpired token,
+// which can then never be used again.
+func useBootstrapToken(token string) bool {
+	bootstrap.Lock()
+	defer bootstrap.Unlock()
+	if len(bootstrap.token) == 0 || now().After(bootstrap.expires) {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(bootstrap.token)) != 1 {
+		return false
+	}
+	bootstrap.token = ""
+	return true
+}
+
+func adminMe(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	if admins, err := Admins(); err != nil || len(admins) > 0 {
+		return err
+	}
+	if !useBootstrapToken(args.String("token")) {
+		b.Reply(m, "That token is invalid or has expired. Restart the bot for a new one.")
+		return nil
+	}
+	u, err := sender(m)
+	if err != nil {
+		return err
+	}
+	before := fmt.Sprint(u.Roles)
+	u.grant(AdminRole)
+	if err := repo.SaveUser(*u); err != nil {
+		return err
+	}
+	Audit(m, "admin me", u.ID, before, u.Roles)
+	b.Reply(m, _BECAME_ADMIN_MSG)
+	return nil
+}
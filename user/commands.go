@@ -0,0 +1,376 @@
+package user
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/audit"
+	"github.com/mackross/go-bot/chat"
+)
+
+var routes = []bot.Route{
+	{Pattern: "hi", Help: "Say hello", HandleErr: hi},
+	{Pattern: "you suck", HandleErr: youSuck},
+	{Pattern: "whoami", Help: "Show what the bot knows about you", HandleErr: whoami},
+	{Pattern: "whois <user>", Help: "Show what the bot knows about a user", Permission: ManageUsersPermission, HandleErr: whois},
+	{Pattern: "docker ps", Help: "List running docker containers", HandleErr: dockerPS},
+	{Pattern: "toggle flag <user> <flag>", Help: "Add or remove a flag on a user", Permission: ManageUsersPermission, HandleErr: toggleFlag},
+	{Pattern: "list users [flag]", Help: "List users, optionally only those with a flag", Permission: ManageUsersPermission, HandleErr: listUsers},
+	// linking lets the chat id act as the user so it needs the same permission as granting roles
+	{Pattern: "link <user> <network> <chat-id>", Help: "Link a chat id on an attached network to a user", Permission: ManageRolesPermission, HandleErr: link},
+	{Pattern: "toggle admin <user>", Help: "Grant or revoke the admin role", Permission: ManageRolesPermission, HandleErr: toggleAdmin},
+	{Pattern: "grant <user> <role>", Help: "Give a user a role", Permission: ManageRolesPermission, HandleErr: grant},
+	{Pattern: "revoke <user> <role>", Help: "Take a role away from a user", Permission: ManageRolesPermission, HandleErr: revoke},
+	{Pattern: "add role <role> <permissions...>", Help: "Create or replace a role with space separated permissions", Permission: ManageRolesPermission, HandleErr: addRole},
+	{Pattern: "remove role <role>", Help: "Remove a role from the bot and every user", Permission: ManageRolesPermission, HandleErr: removeRole},
+	{Pattern: "list roles", Help: "List roles and their permissions", Permission: ManageRolesPermission, HandleErr: listRoles},
+	{Pattern: "audit [user] [n]", Help: "Show the last n changes, optionally only those by or to a user", Permission: ViewAuditPermission, HandleErr: showAudit},
+	{Pattern: _BECOME_ADMIN_MSG + " <token>", Help: "Become the first admin with the token the bot printed at startup", PMOnly: true, HandleErr: adminMe},
+	{Pattern: "change my name", Help: "Change the name the bot calls you", PMOnly: true, HandleErr: changeMyName},
+}
+
+// Audit records a change made by the sender of m. Failing to record it
+// doesn't undo the change so the error is only logged.
+func Audit(m chat.InMsg, action, target string, before, after interface{}) {
+	actor := m.From
+	if u, err := GetUser(m); err == nil && u != nil {
+		actor = u.ID
+	}
+	if err := audit.Record(actor, action, target, fmt.Sprint(before), fmt.Sprint(after)); err != nil {
+		fmt.Println("Unable to record audit entry:", err)
+	}
+}
+
+// sender returns the user who sent m, which the root handler has already saved.
+func sender(m chat.InMsg) (*User, error) {
+	u, err := GetUser(m)
+	if err == nil && u == nil {
+		err = fmt.Errorf("no record found for %v", m.From)
+	}
+	return u, err
+}
+
+func hi(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	u, err := sender(m)
+	if err != nil {
+		return err
+	}
+	name := u.Name
+	if len(name) == 0 {
+		name = u.ID
+	}
+	b.Reply(m, "Hey "+name)
+	return nil
+}
+
+func youSuck(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	b.ReplyPM(m, "tut tut potty mouth")
+	return nil
+}
+
+func whoami(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	u, err := sender(m)
+	if err != nil {
+		return err
+	}
+	b.ReplyPM(m, fmt.Sprintf("ID: %v\nName: %v\n", u.ID, u.Name))
+	return nil
+}
+
+func whois(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	u, err := repo.UserForID(args.String("user"))
+	if err != nil || u == nil {
+		b.ReplyPM(m, fmt.Sprintf("No record found for %v.", args.String("user")))
+		return nil
+	}
+	b.ReplyPM(m, fmt.Sprintf("ID: %v\nName: %v\nRoles: %v\nFlags: %v\nIdentities: %v\n", u.ID, u.Name, u.Roles, u.Flags, u.Identities))
+	return nil
+}
+
+func dockerPS(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	cmd := exec.Command("docker", "ps")
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+
+	reader := io.MultiReader(stdout, stderr)
+	scanner := bufio.NewScanner(reader)
+
+	go func() {
+		if err := cmd.Start(); err != nil {
+			b.ReplyPM(m, fmt.Sprintf("Error occured: %v", err))
+			return
+		}
+		for s := scanner.Scan(); s; s = scanner.Scan() {
+			b.ReplyPM(m, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			b.ReplyPM(m, fmt.Sprintf("Error occured: %v", err))
+		}
+	}()
+	return nil
+}
+
+func toggleFlag(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	u, err := repo.UserForID(args.String("user"))
+	if err != nil || u == nil {
+		b.ReplyPM(m, fmt.Sprintf("No record found for %v.", args.String("user")))
+		return nil
+	}
+	flag := args.String("flag")
+	before := fmt.Sprint(u.Flags)
+	if u.HasFlag(flag) {
+		flags := make([]string, 0, len(u.Flags))
+		for _, f := range u.Flags {
+			if f != flag {
+				flags = append(flags, f)
+			}
+		}
+		u.Flags = flags
+	} else {
+		u.Flags = append(u.Flags, flag)
+	}
+	if err := repo.SaveUser(*u); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err))
+		return nil
+	}
+	Audit(m, "toggle flag", u.ID, before, u.Flags)
+	b.ReplyPM(m, fmt.Sprintf("%v now has flags %v", u.ID, u.Flags))
+	return nil
+}
+
+func listUsers(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	users, err := repo.ListUsers()
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to fetch users due to error: %v", err))
+		return nil
+	}
+	for _, u := range users {
+		if args.Has("flag") && !u.HasFlag(args.String("flag")) {
+			continue
+		}
+		b.ReplyPM(m, fmt.Sprintf("ID: %v\tName: %v\tRoles: %v\tFlags: %v\t", u.ID, u.Name, u.Roles, u.Flags))
+	}
+	return nil
+}
+
+func link(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	b.ReplyPM(m, linkIdentity(b, m, args.String("user"), args.String("network"), args.String("chat-id")))
+	return nil
+}
+
+func toggleAdmin(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	u, err := repo.UserForID(args.String("user"))
+	if err != nil || u == nil {
+		b.ReplyPM(m, fmt.Sprintf("No record found for %v.", args.String("user")))
+		return nil
+	}
+	if u.HasRole(AdminRole) {
+		b.ReplyPM(m, revokeRole(m, u, AdminRole))
+	} else {
+		b.ReplyPM(m, grantRole(m, u, AdminRole))
+	}
+	return nil
+}
+
+func grant(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	u, err := repo.UserForID(args.String("user"))
+	if err != nil || u == nil {
+		b.ReplyPM(m, fmt.Sprintf("No record found for %v.", args.String("user")))
+		return nil
+	}
+	b.ReplyPM(m, grantRole(m, u, args.String("role")))
+	return nil
+}
+
+func revoke(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	u, err := repo.UserForID(args.String("user"))
+	if err != nil || u == nil {
+		b.ReplyPM(m, fmt.Sprintf("No record found for %v.", args.String("user")))
+		return nil
+	}
+	b.ReplyPM(m, revokeRole(m, u, args.String("role")))
+	return nil
+}
+
+func grantRole(m chat.InMsg, u *User, role string) string {
+	r, err := RoleForName(role)
+	if err != nil {
+		return fmt.Sprintf("Unable to fetch role %v due to error: %v", role, err)
+	}
+	if r == nil {
+		return fmt.Sprintf("No role named %v.", role)
+	}
+	if msg, ok := checkDelegation(m, r.Permissions); !ok {
+		return msg
+	}
+	before := fmt.Sprint(u.Roles)
+	if !u.grant(role) {
+		return fmt.Sprintf("%v already has the %v role.", u.ID, role)
+	}
+	if err := repo.SaveUser(*u); err != nil {
+		return fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err)
+	}
+	Audit(m, "grant", u.ID, before, u.Roles)
+	return fmt.Sprintf("%v now has roles %v", u.ID, u.Roles)
+}
+
+func revokeRole(m chat.InMsg, u *User, role string) string {
+	if r, err := RoleForName(role); err == nil && r != nil {
+		if msg, ok := checkDelegation(m, r.Permissions); !ok {
+			return msg
+		}
+	}
+	if role == AdminRole && u.HasRole(AdminRole) {
+		admins, err := Admins()
+		if err != nil {
+			return fmt.Sprintf("Unable to fetch admins due to error: %v", err)
+		}
+		if len(admins) == 1 {
+			return fmt.Sprintf("Unable to revoke %v from the last admin.", AdminRole)
+		}
+	}
+	before := fmt.Sprint(u.Roles)
+	if !u.revoke(role) {
+		return fmt.Sprintf("%v doesn't have the %v role.", u.ID, role)
+	}
+	if err := repo.SaveUser(*u); err != nil {
+		return fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err)
+	}
+	Audit(m, "revoke", u.ID, before, u.Roles)
+	return fmt.Sprintf("%v now has roles %v", u.ID, u.Roles)
+}
+
+// checkDelegation stops the sender of m handing out, or changing who has,
+// permissions they don't have themselves, otherwise managing roles would be
+// as good as being an admin.
+func checkDelegation(m chat.InMsg, permissions []string) (string, bool) {
+	actor, err := sender(m)
+	if err != nil {
+		return fmt.Sprintf("Unable to fetch your record due to error: %v", err), false
+	}
+	if !actor.canDelegate(permissions) {
+		return "Sorry, you can only manage roles with permissions you have.", false
+	}
+	return "", true
+}
+
+func addRole(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	r := Role{args.String("role"), strings.Fields(args.String("permissions"))}
+	// replacing a role changes what everyone with it can do so the
+	// permissions it had count too
+	changed := append([]string{}, r.Permissions...)
+	before := ""
+	if existing, err := RoleForName(r.Name); err == nil && existing != nil {
+		before = strings.Join(existing.Permissions, " ")
+		changed = append(changed, existing.Permissions...)
+	}
+	if msg, ok := checkDelegation(m, changed); !ok {
+		b.ReplyPM(m, msg)
+		return nil
+	}
+	if err := SaveRole(r); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to save role due to error: %v", err))
+		return nil
+	}
+	Audit(m, "add role", r.Name, before, strings.Join(r.Permissions, " "))
+	b.ReplyPM(m, fmt.Sprintf("%v now has permissions %v", r.Name, r.Permissions))
+	return nil
+}
+
+func removeRole(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	role := args.String("role")
+	r, err := RoleForName(role)
+	if err != nil || r == nil {
+		b.ReplyPM(m, fmt.Sprintf("No role named %v.", role))
+		return nil
+	}
+	if msg, ok := checkDelegation(m, r.Permissions); !ok {
+		b.ReplyPM(m, msg)
+		return nil
+	}
+	if err := DeleteRole(role); err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to remove role due to error: %v", err))
+		return nil
+	}
+	users, err := repo.ListUsers()
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to fetch users due to error: %v", err))
+		return nil
+	}
+	for _, u := range users {
+		if u.revoke(role) {
+			if err := repo.SaveUser(u); err != nil {
+				b.ReplyPM(m, fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err))
+				return nil
+			}
+		}
+	}
+	Audit(m, "remove role", role, strings.Join(r.Permissions, " "), "")
+	b.ReplyPM(m, fmt.Sprintf("Removed role %v.", role))
+	return nil
+}
+
+func listRoles(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	roles, err := ListRoles()
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to fetch roles due to error: %v", err))
+		return nil
+	}
+	lines := make([]string, 0, len(roles))
+	for _, r := range roles {
+		lines = append(lines, fmt.Sprintf("%v: %v", r.Name, strings.Join(r.Permissions, " ")))
+	}
+	b.ReplyPM(m, strings.Join(lines, "\n"))
+	return nil
+}
+
+const _DEFAULT_AUDIT_ENTRIES = 10
+
+func showAudit(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	userID, n := args.String("user"), _DEFAULT_AUDIT_ENTRIES
+	if args.Has("n") {
+		i, err := strconv.Atoi(args.String("n"))
+		if err != nil || i < 1 {
+			b.ReplyPM(m, "n must be a positive whole number. Usage: audit [user] [n]")
+			return nil
+		}
+		n = i
+	} else if i, err := strconv.Atoi(userID); err == nil && i > 0 {
+		// "audit 20" is the last 20 changes to anyone, unless there's a user
+		// with the ID 20 as there may be on networks with numeric IDs
+		u, err := UserForID(userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			userID, n = "", i
+		}
+	}
+	entries, err := audit.Entries(userID, n)
+	if err != nil {
+		b.ReplyPM(m, fmt.Sprintf("Unable to fetch audit log due to error: %v", err))
+		return nil
+	}
+	if len(entries) == 0 {
+		b.ReplyPM(m, "No changes recorded.")
+		return nil
+	}
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%v %v %v %v: %v -> %v", e.At.Format(time.RFC3339), e.Actor, e.Action, e.Target, e.Before, e.After))
+	}
+	b.ReplyPM(m, strings.Join(lines, "\n"))
+	return nil
+}
+
+func changeMyName(b *bot.Bot, m chat.InMsg, args bot.Args) error {
+	b.Reply(m, "What would you like to be called?")
+	b.PushHandlerFor(m, &changeNameHandler{m}, nil)
+	return nil
+}
@@ -0,0 +1,154 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// AdminRole grants every permission. It's built in and can't be changed.
+	AdminRole = "admin"
+
+	ManageUsersPermission = "users.manage"
+	ManageRolesPermission = "roles.manage"
+	ViewAuditPermission   = "audit.view"
+
+	_ALL_PERMISSIONS = "*"
+)
+
+// Role is a named set of permissions granted to users. Modules declare the
+// permissions their commands need, e.g. "okrs.export".
+type Role struct {
+	Name        string
+	Permissions []string
+}
+
+func (r *Role) Grants(permission string) bool {
+	for _, p := range r.Permissions {
+		if p == permission || p == _ALL_PERMISSIONS {
+			return true
+		}
+	}
+	return false
+}
+
+var adminRole = Role{AdminRole, []string{_ALL_PERMISSIONS}}
+
+// RoleRepo stores roles. Repos that don't implement it only have the admin
+// role.
+type RoleRepo interface {
+	RoleForName(name string) (*Role, error)
+	ListRoles() ([]Role, error)
+	SaveRole(r Role) error
+	DeleteRole(name string) error
+}
+
+func roleRepo() (RoleRepo, error) {
+	if r, ok := repo.(RoleRepo); ok {
+		return r, nil
+	}
+	return nil, errors.New("user repo doesn't store roles")
+}
+
+// RoleForName returns the role called name, or nil if there isn't one.
+func RoleForName(name string) (*Role, error) {
+	if name == AdminRole {
+		r := adminRole
+		return &r, nil
+	}
+	roles, err := roleRepo()
+	if err != nil {
+		return nil, nil
+	}
+	return roles.RoleForName(name)
+}
+
+// ListRoles returns the admin role followed by the stored roles.
+func ListRoles() ([]Role, error) {
+	all := []Role{adminRole}
+	roles, err := roleRepo()
+	if err != nil {
+		return all, nil
+	}
+	stored, err := roles.ListRoles()
+	if err != nil {
+		return nil, err
+	}
+	return append(all, stored...), nil
+}
+
+func SaveRole(r Role) error {
+	if r.Name == AdminRole {
+		return fmt.Errorf("The %v role can't be changed", AdminRole)
+	}
+	if len(r.Name) == 0 {
+		return errors.New("role name must be set to save role")
+	}
+	roles, err := roleRepo()
+	if err != nil {
+		return err
+	}
+	return roles.SaveRole(r)
+}
+
+func DeleteRole(name string) error {
+	if name == AdminRole {
+		return fmt.Errorf("The %v role can't be removed", AdminRole)
+	}
+	roles, err := roleRepo()
+	if err != nil {
+		return err
+	}
+	return roles.DeleteRole(name)
+}
+
+func (u *User) HasRole(name string) bool {
+	for _, r := range u.Roles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether any of the user's roles grants permission.
+func (u *User) Can(permission string) bool {
+	for _, name := range u.Roles {
+		r, err := RoleForName(name)
+		if err == nil && r != nil && r.Grants(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// canDelegate reports whether the user may hand out every one of permissions,
+// which they may only do when they have them. Only admins may hand out *.
+func (u *User) canDelegate(permissions []string) bool {
+	for _, p := range permissions {
+		if p == _ALL_PERMISSIONS && !u.HasRole(AdminRole) || !u.Can(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) grant(role string) bool {
+	if u.HasRole(role) {
+		return false
+	}
+	u.Roles = append(u.Roles, role)
+	return true
+}
+
+func (u *User) revoke(role string) bool {
+	roles := make([]string, 0, len(u.Roles))
+	for _, r := range u.Roles {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	revoked := len(roles) != len(u.Roles)
+	u.Roles = roles
+	return revoked
+}
@@ -1,16 +1,18 @@
 package user
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"os/exec"
-	"strings"
 
 	"github.com/mackross/go-bot"
 	"github.com/mackross/go-bot/chat"
 )
 
+// ErrNotFound may be returned by Repo.UserForID when there's no user with the
+// ID, which repos may also report with a nil user.
+var ErrNotFound = errors.New("not found")
+
 type Repo interface {
 	UserForID(id string) (*User, error)
 	ListUsers() ([]User, error)
@@ -23,10 +25,32 @@ const (
 )
 
 type User struct {
-	ID      string
-	Name    string
-	IsAdmin bool
-	Flags   []string
+	ID         string
+	Name       string
+	Roles      []string
+	Flags      []string
+	Network    string            // network the ID was first seen on, empty for the bot's own
+	Identities map[string]string // chat IDs on other networks keyed by network name
+}
+
+// ChatID returns the user's chat ID on network.
+func (u *User) ChatID(network string) (string, bool) {
+	if id, ok := u.Identities[network]; ok {
+		return id, true
+	}
+	if len(network) == 0 && len(u.Network) == 0 {
+		return u.ID, true
+	}
+	return "", false
+}
+
+// SendPM messages the user on network.
+func (u *User) SendPM(b *bot.Bot, network string, body string) error {
+	id, ok := u.ChatID(network)
+	if !ok {
+		return fmt.Errorf("%v has no identity on network %v", u.ID, network)
+	}
+	return b.SendPMOn(network, chat.OutMsg{To: id, Body: body})
 }
 
 func (u *User) HasFlag(s string) bool {
@@ -45,216 +69,189 @@ func SetRepo(r Repo) {
 }
 
 type userRootHandler struct {
+	*bot.Router
 }
 
+// NewRootHandler handles the user commands. Call Bootstrap as well so a fresh
+// deployment can get its first admin.
 func NewRootHandler() bot.MessageHandler {
-	return &userRootHandler{}
+	r := bot.NewRouter(Authorize)
+	for _, route := range routes {
+		panicErr(r.AddRoute(route))
+	}
+	return bot.HandleErrors(&userRootHandler{r})
 }
 
-func (r *userRootHandler) HandleMessage(b *bot.Bot, m chat.InMsg) bool {
+// Authorize reports whether the sender of m has a role granting permission.
+// Other modules pass it to bot.NewRouter.
+func Authorize(m chat.InMsg, permission string) bool {
 	u, err := GetUser(m)
-	panicErr(err)
-
-	if u == nil {
-		u = &User{ID: m.From}
-		err = repo.SaveUser(*u)
-		panicErr(err)
-	}
-	lower := strings.ToLower(m.Body)
-	if lower == "hi" {
-		name := u.Name
-		if len(name) == 0 {
-			name = u.ID
-		}
-		b.Reply(m, "Hey "+name)
-		return true
-	}
-
-	if lower == "you suck" {
-		b.ReplyPM(m, "tut tut potty mouth")
-		return true
+	if err != nil || u == nil {
+		return false
 	}
+	return u.Can(permission)
+}
 
-	if lower == "whoami" {
-		b.ReplyPM(m, fmt.Sprintf("ID: %v\nName: %v\n", u.ID, u.Name))
-		return true
+// HandleMessageErr saves anyone the bot hasn't seen before then runs their
+// command.
+func (r *userRootHandler) HandleMessageErr(b *bot.Bot, m chat.InMsg) (bool, error) {
+	u, err := GetUser(m)
+	if err != nil {
+		return false, err
 	}
-	if strings.HasPrefix(lower, "whois ") && u.IsAdmin {
-		split := strings.Split(m.Body, " ")
-		if len(split) == 2 {
-			u, err := repo.UserForID(split[1])
-			if err != nil || u == nil {
-				b.ReplyPM(m, fmt.Sprintf("No record found for %v.", split[1]))
-			} else {
-				b.ReplyPM(m, fmt.Sprintf("ID: %v\nName: %v\nAdmin: %v\nFlags: %v\n", u.ID, u.Name, u.IsAdmin, u.Flags))
-
-			}
-			return true
+	if u == nil {
+		if err := repo.SaveUser(*newUser(m)); err != nil {
+			return false, err
 		}
 	}
+	return r.Router.HandleMessage(b, m), nil
+}
 
-	if lower == "docker ps" {
-		cmd := exec.Command("docker", "ps")
-		stdout, _ := cmd.StdoutPipe()
-		stderr, _ := cmd.StderrPipe()
+const _CHANGE_NAME_KIND = "user.change-name"
 
-		reader := io.MultiReader(stdout, stderr)
-		scanner := bufio.NewScanner(reader)
+func init() {
+	bot.RegisterHandlerKind(_CHANGE_NAME_KIND, restoreChangeNameHandler)
+}
 
-		go func() {
-			cmd.Start()
-			for s := scanner.Scan(); s; s = scanner.Scan() {
-				t := scanner.Text()
-				b.ReplyPM(m, t)
-			}
-			if err != nil && err != io.EOF {
-				b.ReplyPM(m, fmt.Sprintf("Error occured: %v", err))
-			}
-		}()
+// changeNameHandler waits for the name asked for by "change my name". It's
+// persistent so the question is still answered after the bot restarts.
+type changeNameHandler struct {
+	Asked chat.InMsg
+}
+
+func (c *changeNameHandler) HandleMessage(b *bot.Bot, m chat.InMsg) bool {
+	if !m.IsPM() || m.From != c.Asked.From || m.Network != c.Asked.Network {
+		return false
+	}
+	defer b.PopHandler(c)
+	u, err := GetUser(m)
+	if u == nil || err != nil {
+		b.Reply(m, "Sorry "+m.Body+". Something went wrong try the command again from the start.")
 		return true
 	}
+	u.Name = m.Body
+	repo.SaveUser(*u)
+	b.Reply(m, u.Name+" it is.")
+	return true
+}
 
-	if u.IsAdmin && strings.HasPrefix(lower, "toggle flag ") && len(strings.Split(lower, " ")) == 4 {
-		split := strings.Split(m.Body, " ")
-		u, err := repo.UserForID(split[2])
-		if err != nil || u == nil {
-			b.ReplyPM(m, fmt.Sprintf("No record found for %v.", split[1]))
-		} else {
-			if u.Flags == nil {
-				u.Flags = make([]string, 0)
-			}
-			for i, flag := range u.Flags {
-				if flag == split[3] {
-					u.Flags[i], u.Flags = u.Flags[len(u.Flags)-1], u.Flags[:len(u.Flags)-1] // delete flag
-					err := repo.SaveUser(*u)
-					if err != nil {
-						b.ReplyPM(m, fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err))
-						return true
-					}
-					b.ReplyPM(m, fmt.Sprintf("%v now has flags %v", u.ID, u.Flags))
-					return true
-				}
-			}
-			u.Flags = append(u.Flags, split[3])
-			err := repo.SaveUser(*u)
-			if err != nil {
-				b.ReplyPM(m, fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err))
-				return true
-			}
-			b.ReplyPM(m, fmt.Sprintf("%v now has flags %v", u.ID, u.Flags))
+func (c *changeNameHandler) HandlerKind() string {
+	return _CHANGE_NAME_KIND
+}
 
-		}
-		return true
-	}
+func (c *changeNameHandler) HandlerState() ([]byte, error) {
+	return json.Marshal(c)
+}
 
-	if u.IsAdmin && strings.HasPrefix(lower, "list users") && len(strings.Split(lower, " ")) == 2 || len(strings.Split(lower, " ")) == 3 {
-		users, err := repo.ListUsers()
-		if err != nil {
-			b.ReplyPM(m, fmt.Sprintf("Unable to fetch users due to error: %v", err))
-		}
-		var flag *string
-		if len(strings.Split(lower, " ")) == 3 {
-			flag = &strings.Split(lower, " ")[2]
-		}
-		for _, u := range users {
-			if flag != nil && !u.HasFlag(*flag) {
-				continue
-			}
-			b.ReplyPM(m, fmt.Sprintf("ID: %v\tName: %v\tAdmin: %v\tFlags: %v\t", u.ID, u.Name, u.IsAdmin, u.Flags))
-		}
-	}
+func restoreChangeNameHandler(b *bot.Bot, state []byte) (bot.MessageHandler, error) {
+	c := &changeNameHandler{}
+	return c, json.Unmarshal(state, c)
+}
 
-	if u.IsAdmin && strings.HasPrefix(lower, "toggle admin ") && len(strings.Split(lower, " ")) == 3 {
-		split := strings.Split(m.Body, " ")
-		u, err := repo.UserForID(split[2])
-		if err != nil || u == nil {
-			b.ReplyPM(m, fmt.Sprintf("No record found for %v.", split[1]))
-		} else {
-			u.IsAdmin = !u.IsAdmin
-			err := repo.SaveUser(*u)
-			if err != nil {
-				b.ReplyPM(m, fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err))
-				return true
-			}
-			if u.IsAdmin {
-				b.ReplyPM(m, fmt.Sprintf("%v is now an admin.", u.ID))
-			} else {
-				b.ReplyPM(m, fmt.Sprintf("%v is no longer an admin.", u.ID))
-			}
+func Admins() ([]User, error) {
+	admins := make([]User, 0)
+	users, err := repo.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if u.HasRole(AdminRole) {
+			admins = append(admins, u)
 		}
-		return true
 	}
+	return admins, nil
+}
 
-	if m.IsPM() {
-		if m.Body == _BECOME_ADMIN_MSG && len(Admins()) == 0 {
-			u.IsAdmin = true
-			err = repo.SaveUser(*u)
-			b.Reply(m, _BECAME_ADMIN_MSG)
-			panicErr(err)
-			return true
+// GetUser finds the user who sent m, following linked identities for messages
+// from attached networks.
+func GetUser(m chat.InMsg) (*User, error) {
+	if len(m.Network) == 0 {
+		u, err := repo.UserForID(m.From)
+		if err != nil && err != ErrNotFound {
+			return nil, err
 		}
-
-		if lower == "change my name" {
-			b.Reply(m, "What would you like to be called?")
-			from := m.From
-			b.PushHandler(&questionHandler{true, &from, "", func(q *questionHandler) bool {
-				u, err := GetUser(m)
-				if u == nil || err != nil {
-					b.Reply(m, "Sorry "+q.value+". Something went wrong try the command again from the start.")
-					return true
-				}
-				u.Name = q.value
-				repo.SaveUser(*u)
-				b.Reply(m, u.Name+" it is.")
-				return true
-			}}, nil)
-			return true
+		if u != nil {
+			return u, nil
 		}
-
 	}
-
-	return false
+	return userForIdentity(m.Network, m.From)
 }
 
-type questionHandler struct {
-	pmOnly bool
-	userID *string
-	value  string
-	done   func(q *questionHandler) bool
+// UserForID returns the user with id, or nil when there isn't one.
+func UserForID(id string) (*User, error) {
+	u, err := repo.UserForID(id)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return u, nil
 }
 
-func (q *questionHandler) HandleMessage(b *bot.Bot, m chat.InMsg) bool {
-	isCorrectUser := q.userID == nil || m.From == *q.userID
-	isCorrectType := !q.pmOnly || m.IsPM()
-	if isCorrectType && isCorrectUser {
-		q.value = m.Body
-		if q.done == nil || q.done(q) {
-			b.PopHandler(q)
-			return true
-		}
+// IDFor returns the ID of the user who sent m, or the ID they'll be given when
+// the bot hasn't seen them before. Modules should key what users own by it
+// rather than by chat ID.
+func IDFor(m chat.InMsg) (string, error) {
+	u, err := GetUser(m)
+	if err != nil {
+		return "", err
 	}
-	return false
+	if u == nil {
+		return newUser(m).ID, nil
+	}
+	return u.ID, nil
 }
 
-func Admins() []User {
-	admins := make([]User, 0)
+func userForIdentity(network string, chatID string) (*User, error) {
 	users, err := repo.ListUsers()
-	panicErr(err)
+	if err != nil {
+		return nil, err
+	}
 	for _, u := range users {
-		if u.IsAdmin {
-			admins = append(admins, u)
+		if id, ok := u.Identities[network]; ok && id == chatID {
+			return &u, nil
 		}
 	}
-	return admins
+	return nil, nil
+}
 
+// newUser creates a user for the sender of m. Users first seen on an attached
+// network have their ID prefixed by the network name so IDs never collide.
+func newUser(m chat.InMsg) *User {
+	if len(m.Network) == 0 {
+		return &User{ID: m.From}
+	}
+	return &User{ID: m.Network + ":" + m.From, Network: m.Network, Identities: map[string]string{m.Network: m.From}}
 }
 
-func GetUser(m chat.InMsg) (*User, error) {
-	u, err := repo.UserForID(m.From)
-	if err != nil && err.Error() != "not found" {
-		return nil, err
+// linkIdentity makes chatID on network resolve to the user with userID,
+// taking it away from any user it was linked to before.
+func linkIdentity(b *bot.Bot, m chat.InMsg, userID string, network string, chatID string) string {
+	if b.NetworkNamed(network) == nil {
+		return fmt.Sprintf("No network attached with name %v.", network)
 	}
-	return u, nil
+	u, err := repo.UserForID(userID)
+	if err != nil || u == nil {
+		return fmt.Sprintf("No record found for %v.", userID)
+	}
+	previous, err := userForIdentity(network, chatID)
+	if err != nil {
+		return fmt.Sprintf("Unable to link %v due to error: %v", u.ID, err)
+	}
+	if previous != nil && previous.ID != u.ID {
+		delete(previous.Identities, network)
+		if err := repo.SaveUser(*previous); err != nil {
+			return fmt.Sprintf("Unable to save change to %v due to error: %v", previous.ID, err)
+		}
+	}
+	if u.Identities == nil {
+		u.Identities = make(map[string]string, 0)
+	}
+	before := u.Identities[network]
+	u.Identities[network] = chatID
+	if err := repo.SaveUser(*u); err != nil {
+		return fmt.Sprintf("Unable to save change to %v due to error: %v", u.ID, err)
+	}
+	Audit(m, "link "+network, u.ID, before, chatID)
+	return fmt.Sprintf("%v is now %v on %v.", u.ID, chatID, network)
 }
 
 func panicErr(err error) {
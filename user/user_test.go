@@ -1,11 +1,13 @@
 package user
 
 import (
-	"errors"
-	"github.com/mackross/gobot"
-	"github.com/mackross/gobot/bottest"
-	"github.com/mackross/gobot/chat"
+	"github.com/mackross/go-bot"
+	"github.com/mackross/go-bot/audit"
+	"github.com/mackross/go-bot/bottest"
+	"github.com/mackross/go-bot/chat"
+	"github.com/mackross/go-bot/cmd"
 	"testing"
+	"time"
 )
 
 type mockRepo map[string]User
@@ -25,7 +27,7 @@ func (m mockRepo) ListUsers() ([]User, error) {
 func (m mockRepo) UserForID(id string) (*User, error) {
 	u, ok := m[id]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, ErrNotFound
 	}
 	return &u, nil
 }
@@ -64,18 +66,56 @@ func TestThatOneUserCanBecomeAdminThroughPM(t *testing.T) {
 	SetRepo(repo)
 
 	b.AddRootHandler(NewRootHandler())
+	ok(t, Bootstrap())
+	adminMe := _BECOME_ADMIN_MSG + " " + bootstrap.token
 
 	roomID := "some room"
-	b.HandleMessage(chat.InMsg{From: "123", RoomID: &roomID, Body: _BECOME_ADMIN_MSG})
+	b.HandleMessage(chat.InMsg{From: "123", RoomID: &roomID, Body: adminMe})
+	equals(t, 0, len(repo.admins()))
+	c.ExpectPM(chat.OutMsg{To: "123", Body: "Missing token. Usage: admin me <token>"})
+	c.ExpectPM(chat.OutMsg{To: "123", Body: "That token is invalid or has expired. Restart the bot for a new one."})
+	b.HandleMessage(chat.InMsg{From: "123", Body: _BECOME_ADMIN_MSG})
+	b.HandleMessage(chat.InMsg{From: "123", Body: _BECOME_ADMIN_MSG + " guess"})
 	equals(t, 0, len(repo.admins()))
 	c.ExpectPM(chat.OutMsg{To: "1234", Body: _BECAME_ADMIN_MSG})
-	b.HandleMessage(chat.InMsg{From: "1234", Body: _BECOME_ADMIN_MSG})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: adminMe})
 	equals(t, 1, len(repo.admins()))
-	b.HandleMessage(chat.InMsg{From: "123", Body: _BECOME_ADMIN_MSG})
+	b.HandleMessage(chat.InMsg{From: "123", Body: adminMe})
 	equals(t, 1, len(repo.admins()))
 
 	c.Check()
 }
+
+func TestThatBootstrapTokensExpireAndAreUsedOnce(t *testing.T) {
+	b, c := mockBot(t)
+	SetRepo(nil)
+	assert(t, Bootstrap() != nil, "expected error bootstrapping without a repo")
+	repo := newMockRepo()
+	SetRepo(repo)
+	jan1st2015 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return jan1st2015 }
+	defer func() { now = time.Now }()
+
+	b.AddRootHandler(NewRootHandler())
+	ok(t, Bootstrap())
+	token := bootstrap.token
+	equals(t, 32, len(token))
+	ok(t, Bootstrap())
+	assert(t, token != bootstrap.token, "expected a new token each startup")
+	token = bootstrap.token
+
+	now = func() time.Time { return jan1st2015.Add(BootstrapTTL + time.Second) }
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "That token is invalid or has expired. Restart the bot for a new one."})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: _BECOME_ADMIN_MSG + " " + token})
+	equals(t, 0, len(repo.admins()))
+
+	now = func() time.Time { return jan1st2015 }
+	assert(t, useBootstrapToken(token), "expected token to be valid")
+	assert(t, !useBootstrapToken(token), "expected token to be used once")
+
+	c.Check()
+}
+
 func TestThatUserCanChangeTheirName(t *testing.T) {
 	b, c := mockBot(t)
 	_, _ = b, c
@@ -97,12 +137,222 @@ func TestThatUserCanChangeTheirName(t *testing.T) {
 	equals(t, "Batman", repo["1234"].Name)
 }
 
+func TestThatChangingNameCarriesOnAfterARestart(t *testing.T) {
+	r, cleanup := tempBoltRepo(t)
+	defer cleanup()
+	SetRepo(r)
+	store := cmd.NewBoltStore(r.DB)
+
+	b, c := mockBot(t)
+	b.AddRootHandler(NewRootHandler())
+	ok(t, b.Persist(store))
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "What would you like to be called?"})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "change my name"})
+	c.Check()
+
+	restarted, c := mockBot(t)
+	restarted.AddRootHandler(NewRootHandler())
+	ok(t, restarted.Persist(store))
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "Batman it is."})
+	restarted.HandleMessage(chat.InMsg{From: "1234", Body: "Batman"})
+	c.Check()
+
+	u, err := r.UserForID("1234")
+	ok(t, err)
+	equals(t, "Batman", u.Name)
+	saved, err := store.LoadStack()
+	ok(t, err)
+	equals(t, 0, len(saved))
+}
+
 func (m mockRepo) admins() []User {
 	users := make([]User, 0)
 	for _, u := range m {
-		if u.IsAdmin {
+		if u.HasRole(AdminRole) {
 			users = append(users, u)
 		}
 	}
 	return users
 }
+
+func TestThatIdentitiesCanBeLinkedAcrossNetworks(t *testing.T) {
+	b, c := mockBot(t)
+	irc := bottest.NewChat(t)
+	ok(t, b.AttachNetwork("irc", irc))
+	repo := newMockRepo()
+	SetRepo(repo)
+	repo.SaveUser(User{ID: "1234", Name: "Bruce", Roles: []string{AdminRole}})
+
+	b.AddRootHandler(NewRootHandler())
+
+	irc.ExpectPM(chat.OutMsg{To: "bruce", Body: "Hey irc:bruce"})
+	b.HandleMessage(chat.InMsg{Network: "irc", From: "bruce", Body: "hi"})
+	equals(t, "irc", repo["irc:bruce"].Network)
+
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "No network attached with name slack."})
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "1234 is now bruce on irc."})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "link 1234 slack bruce"})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "link 1234 irc bruce"})
+	equals(t, 0, len(repo["irc:bruce"].Identities))
+
+	irc.ExpectPM(chat.OutMsg{To: "bruce", Body: "Hey Bruce"})
+	b.HandleMessage(chat.InMsg{Network: "irc", From: "bruce", Body: "hi"})
+
+	u := repo["1234"]
+	irc.ExpectPM(chat.OutMsg{To: "bruce", Body: "ping"})
+	ok(t, u.SendPM(b, "irc", "ping"))
+	id, _ := u.ChatID("")
+	equals(t, "1234", id)
+
+	c.Check()
+	irc.Check()
+}
+
+func TestThatAdminsCanToggleFlagsAndListUsers(t *testing.T) {
+	b, c := mockBot(t)
+	repo := newMockRepo()
+	SetRepo(repo)
+	repo.SaveUser(User{ID: "1234", Roles: []string{AdminRole}})
+	repo.SaveUser(User{ID: "123"})
+
+	b.AddRootHandler(NewRootHandler())
+
+	c.ExpectPM(chat.OutMsg{To: "123", Body: "Sorry, you're not allowed to list users."})
+	b.HandleMessage(chat.InMsg{From: "123", Body: "list users"})
+
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "123 now has flags [okr]"})
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "ID: 123\tName: \tRoles: []\tFlags: [okr]\t"})
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "No record found for 999."})
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "Missing flag. Usage: toggle flag <user> <flag>"})
+	c.ExpectPM(chat.OutMsg{To: "1234", Body: "123 now has flags []"})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "toggle flag 123 okr"})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "list users okr"})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "toggle flag 999 okr"})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "toggle flag 123"})
+	b.HandleMessage(chat.InMsg{From: "1234", Body: "toggle flag 123 okr"})
+
+	c.Check()
+}
+
+func TestThatRolesCanBeGrantedAndRevoked(t *testing.T) {
+	b, c := mockBot(t)
+	repo, cleanup := tempBoltRepo(t)
+	defer cleanup()
+	SetRepo(repo)
+	ok(t, repo.SaveUser(User{ID: "batman", Roles: []string{AdminRole}}))
+	ok(t, repo.SaveUser(User{ID: "robin"}))
+
+	b.AddRootHandler(NewRootHandler())
+
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Sorry, you're not allowed to grant."})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "grant robin admin"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "No role named okr."})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "okr now has permissions [okrs.export users.manage]"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "robin now has roles [okr]"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "admin: *\nokr: okrs.export users.manage"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "grant robin okr"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "add role okr okrs.export users.manage"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "grant robin okr"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "list roles"})
+
+	robin, _ := repo.UserForID("robin")
+	assert(t, robin.Can("okrs.export"), "expected robin to be able to export okrs")
+	assert(t, !robin.Can(ManageRolesPermission), "expected robin not to be able to manage roles")
+
+	// robin may now manage users but still not roles
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "ID: batman\tName: \tRoles: [admin]\tFlags: []\t"})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "ID: robin\tName: \tRoles: [okr]\tFlags: []\t"})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Sorry, you're not allowed to revoke."})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "Sorry, you're not allowed to link."})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "list users"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "revoke batman admin"})
+	// or become batman by linking batman to a chat id robin controls
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "link batman irc robin"})
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Unable to revoke admin from the last admin."})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Unable to save role due to error: The admin role can't be changed"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "Removed role okr."})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "revoke batman admin"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "add role admin nothing"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "remove role okr"})
+
+	robin, _ = repo.UserForID("robin")
+	equals(t, 0, len(robin.Roles))
+	c.Check()
+
+	// managing roles doesn't let robin hand out permissions robin doesn't have
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "managers now has permissions [roles.manage]"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "robin now has roles [managers]"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "add role managers roles.manage"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "grant robin managers"})
+	denied := "Sorry, you can only manage roles with permissions you have."
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: denied})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: denied})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: denied})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: denied})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: denied})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "helpers now has permissions [roles.manage]"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "add role super *"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "add role users users.manage"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "grant robin admin"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "toggle admin robin"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "revoke batman admin"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "add role helpers roles.manage"})
+
+	robin, _ = repo.UserForID("robin")
+	equals(t, []string{"managers"}, robin.Roles)
+
+	c.Check()
+}
+
+func TestThatChangesAreAudited(t *testing.T) {
+	b, c := mockBot(t)
+	repo, cleanup := tempBoltRepo(t)
+	defer cleanup()
+	SetRepo(repo)
+	audit.SetRepo(audit.NewBoltRepo(repo.DB))
+	defer audit.SetRepo(nil)
+
+	b.AddRootHandler(NewRootHandler())
+	ok(t, Bootstrap())
+
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: _BECAME_ADMIN_MSG})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "robin now has flags [okr]"})
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "robin now has roles [admin]"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: _BECOME_ADMIN_MSG + " " + bootstrap.token})
+	b.HandleMessage(chat.InMsg{From: "robin"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "toggle flag robin okr"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "grant robin admin"})
+
+	entries, err := audit.Entries("", 10)
+	ok(t, err)
+	equals(t, 3, len(entries))
+	e := entries[0]
+	equals(t, []string{"batman", "grant", "robin", "[]", "[admin]"}, []string{e.Actor, e.Action, e.Target, e.Before, e.After})
+	e = entries[2]
+	equals(t, []string{"batman", "admin me", "batman", "[]", "[admin]"}, []string{e.Actor, e.Action, e.Target, e.Before, e.After})
+
+	at := func(e audit.Entry) string { return e.At.Format(time.RFC3339) }
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: at(entries[0]) + " batman grant robin: [] -> [admin]\n" + at(entries[1]) + " batman toggle flag robin: [] -> [okr]"})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: at(entries[0]) + " batman grant robin: [] -> [admin]"})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: at(entries[0]) + " batman grant robin: [] -> [admin]"})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "No changes recorded."})
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: "n must be a positive whole number. Usage: audit [user] [n]"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "audit robin"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "audit robin 1"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "audit 1"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "audit joker"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "audit robin lots"})
+	c.Check()
+
+	// a numeric argument is a user when there's a user with that ID
+	c.ExpectPM(chat.OutMsg{To: "batman", Body: "12345 now has flags [okr]"})
+	b.HandleMessage(chat.InMsg{From: "12345"})
+	b.HandleMessage(chat.InMsg{From: "batman", Body: "toggle flag 12345 okr"})
+	entries, err = audit.Entries("", 1)
+	ok(t, err)
+	c.ExpectPM(chat.OutMsg{To: "robin", Body: at(entries[0]) + " batman toggle flag 12345: [] -> [okr]"})
+	b.HandleMessage(chat.InMsg{From: "robin", Body: "audit 12345"})
+	c.Check()
+}
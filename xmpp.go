@@ -0,0 +1,549 @@
+package bot
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+const (
+	nsStream  = "http://etherx.jabber.org/streams"
+	nsTLS     = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL    = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind    = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsSession = "urn:ietf:params:xml:ns:xmpp-session"
+	nsMUC     = "http://jabber.org/protocol/muc"
+	nsMUCUser = "http://jabber.org/protocol/muc#user"
+	nsPing    = "urn:xmpp:ping"
+)
+
+type XMPPConfig struct {
+	JID          string // user@domain
+	Password     string
+	Server       string // host:port, defaults to the JID domain on port 5222
+	Resource     string
+	Nick         string // room nickname, defaults to the JID user
+	MUCDomain    string // rooms without a domain are joined on this, defaults to conference.<domain>
+	NoTLS        bool   // skip STARTTLS, only for local servers
+	TLSConfig    *tls.Config
+	PingInterval time.Duration // XEP-0199 keepalive, defaults to one minute
+}
+
+// XMPPNetwork is a chat.Network for any standards compliant XMPP server.
+// Rooms are XEP-0045 multi-user chats addressed by name (or full room JID when
+// not on MUCDomain). Senders are identified by the real bare JID the room
+// reports for each occupant, falling back to their room nick in anonymous
+// rooms. Unlike HipChatNetwork no REST API is needed.
+type XMPPNetwork struct {
+	lastID uint64 // first for 64 bit alignment of atomic access
+	sync.RWMutex
+	config       XMPPConfig
+	user         string
+	domain       string
+	conn         net.Conn
+	writeMu      sync.Mutex
+	lastReceived time.Time
+	rooms        map[string]bool   // bare room jid -> joined
+	occupants    map[string]string // occupant jid -> real bare jid
+	messages     chan chat.InMsg
+	onConnect    chan bool
+}
+
+func XMPPConnect(c XMPPConfig) *XMPPNetwork {
+	x := newXMPPNetwork(c)
+	go func() {
+		for {
+			fmt.Println("Attempting to connect as", c.JID)
+			if err := x.connectAndRead(); err != nil {
+				fmt.Println("XMPP connection lost:", err)
+			}
+			fmt.Println("Retrying in 3 seconds")
+			time.Sleep(3 * time.Second)
+		}
+	}()
+	return x
+}
+
+func newXMPPNetwork(c XMPPConfig) *XMPPNetwork {
+	user, domain := c.JID, ""
+	if split := strings.SplitN(c.JID, "@", 2); len(split) == 2 {
+		user, domain = split[0], strings.SplitN(split[1], "/", 2)[0]
+	}
+	if len(c.Server) == 0 {
+		c.Server = domain + ":5222"
+	}
+	if len(c.Resource) == 0 {
+		c.Resource = "bot"
+	}
+	if len(c.Nick) == 0 {
+		c.Nick = user
+	}
+	if len(c.MUCDomain) == 0 {
+		c.MUCDomain = "conference." + domain
+	}
+	if c.PingInterval == 0 {
+		c.PingInterval = time.Minute
+	}
+	return &XMPPNetwork{
+		config:    c,
+		user:      user,
+		domain:    domain,
+		rooms:     make(map[string]bool, 0),
+		occupants: make(map[string]string, 0),
+		messages:  make(chan chat.InMsg, 0),
+		onConnect: make(chan bool, 1),
+	}
+}
+
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms *struct {
+		Mechanism []string `xml:"mechanism"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms"`
+	Bind    *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Session *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-session session"`
+}
+
+type xmppMessage struct {
+	From  string    `xml:"from,attr"`
+	Type  string    `xml:"type,attr"`
+	ID    string    `xml:"id,attr"`
+	Body  string    `xml:"body"`
+	Delay *struct{} `xml:"urn:xmpp:delay delay"`
+}
+
+type xmppPresence struct {
+	From string `xml:"from,attr"`
+	Type string `xml:"type,attr"`
+	X    *struct {
+		Item struct {
+			JID string `xml:"jid,attr"`
+		} `xml:"item"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+}
+
+type xmppIQ struct {
+	From string    `xml:"from,attr"`
+	Type string    `xml:"type,attr"`
+	ID   string    `xml:"id,attr"`
+	Ping *struct{} `xml:"urn:xmpp:ping ping"`
+	Bind *struct {
+		JID string `xml:"jid"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// xmppStream reads stanzas from a single connection.
+type xmppStream struct {
+	dec *xml.Decoder
+}
+
+func (s *xmppStream) next() (xml.StartElement, error) {
+	for {
+		t, err := s.dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			if t.Name.Space == nsStream && t.Name.Local == "stream" {
+				return xml.StartElement{}, io.EOF
+			}
+		}
+	}
+}
+
+// open starts a new stream on the connection and returns its features.
+func (x *XMPPNetwork) open(conn net.Conn) (*xmppStream, *xmppFeatures, error) {
+	x.Lock()
+	x.conn = conn
+	x.Unlock()
+	err := x.write("<?xml version='1.0'?><stream:stream to='%v' xmlns='jabber:client' xmlns:stream='%v' version='1.0'>", xmlEscape(x.domain), nsStream)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &xmppStream{xml.NewDecoder(conn)}
+	for {
+		se, err := s.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if se.Name.Space == nsStream && se.Name.Local == "features" {
+			f := &xmppFeatures{}
+			if err := s.dec.DecodeElement(f, &se); err != nil {
+				return nil, nil, err
+			}
+			return s, f, nil
+		}
+	}
+}
+
+func (x *XMPPNetwork) connectAndRead() error {
+	conn, err := net.DialTimeout("tcp", x.config.Server, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		x.Lock()
+		x.conn.Close()
+		x.conn = nil
+		x.Unlock()
+	}()
+
+	s, features, err := x.open(conn)
+	if err != nil {
+		return err
+	}
+	if !x.config.NoTLS {
+		// never fall back to sending the password in the clear
+		if features.StartTLS == nil {
+			return errors.New("server does not offer STARTTLS")
+		}
+		if err := x.write("<starttls xmlns='%v'/>", nsTLS); err != nil {
+			return err
+		}
+		if se, err := s.next(); err != nil {
+			return err
+		} else if se.Name.Local != "proceed" {
+			return errors.New("server refused starttls")
+		}
+		tlsConfig := x.config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: strings.Split(x.config.Server, ":")[0]}
+		}
+		conn = tls.Client(conn, tlsConfig)
+		if s, features, err = x.open(conn); err != nil {
+			return err
+		}
+	}
+
+	if err := x.authenticate(s, features); err != nil {
+		return err
+	}
+	if s, features, err = x.open(conn); err != nil {
+		return err
+	}
+	if err := x.bind(s, features); err != nil {
+		return err
+	}
+
+	x.write("<presence/>")
+	x.Lock()
+	x.lastReceived = time.Now()
+	rooms := make([]string, 0, len(x.rooms))
+	for r := range x.rooms {
+		rooms = append(rooms, r)
+	}
+	x.Unlock()
+	for _, r := range rooms {
+		x.joinRoom(r)
+	}
+	fmt.Println("Connected")
+	select {
+	case x.onConnect <- true:
+	default:
+	}
+
+	done := make(chan bool)
+	defer close(done)
+	go x.keepAlive(conn, done)
+
+	for {
+		se, err := s.next()
+		if err != nil {
+			return err
+		}
+		if err := x.handleStanza(s, se); err != nil {
+			return err
+		}
+	}
+}
+
+func (x *XMPPNetwork) authenticate(s *xmppStream, f *xmppFeatures) error {
+	plain := false
+	if f.Mechanisms != nil {
+		for _, m := range f.Mechanisms.Mechanism {
+			plain = plain || m == "PLAIN"
+		}
+	}
+	if !plain {
+		return errors.New("server does not support SASL PLAIN")
+	}
+	creds := base64.StdEncoding.EncodeToString([]byte("\x00" + x.user + "\x00" + x.config.Password))
+	if err := x.write("<auth xmlns='%v' mechanism='PLAIN'>%v</auth>", nsSASL, creds); err != nil {
+		return err
+	}
+	se, err := s.next()
+	if err != nil {
+		return err
+	}
+	if se.Name.Local != "success" {
+		return fmt.Errorf("unable to authenticate as %v", x.config.JID)
+	}
+	return s.dec.Skip()
+}
+
+func (x *XMPPNetwork) bind(s *xmppStream, f *xmppFeatures) error {
+	if f.Bind == nil {
+		return errors.New("server does not support resource binding")
+	}
+	if err := x.write("<iq type='set' id='bind'><bind xmlns='%v'><resource>%v</resource></bind></iq>", nsBind, xmlEscape(x.config.Resource)); err != nil {
+		return err
+	}
+	if err := x.expectResult(s, "bind"); err != nil {
+		return err
+	}
+	if f.Session != nil {
+		if err := x.write("<iq type='set' id='session'><session xmlns='%v'/></iq>", nsSession); err != nil {
+			return err
+		}
+		return x.expectResult(s, "session")
+	}
+	return nil
+}
+
+func (x *XMPPNetwork) expectResult(s *xmppStream, id string) error {
+	for {
+		se, err := s.next()
+		if err != nil {
+			return err
+		}
+		if se.Name.Local != "iq" {
+			if err := s.dec.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		iq := xmppIQ{}
+		if err := s.dec.DecodeElement(&iq, &se); err != nil {
+			return err
+		}
+		if iq.ID == id {
+			if iq.Type != "result" {
+				return fmt.Errorf("%v failed", id)
+			}
+			return nil
+		}
+	}
+}
+
+// keepAlive pings the server (XEP-0199) and drops the connection when nothing
+// has been received for two ping intervals so the connect loop can redial.
+func (x *XMPPNetwork) keepAlive(conn net.Conn, done chan bool) {
+	ticker := time.NewTicker(x.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			x.RLock()
+			stale := time.Since(x.lastReceived) > 2*x.config.PingInterval
+			x.RUnlock()
+			if stale {
+				fmt.Println("XMPP ping timed out")
+				conn.Close()
+				return
+			}
+			x.write("<iq type='get' id='%v' to='%v'><ping xmlns='%v'/></iq>", x.newID(), xmlEscape(x.domain), nsPing)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (x *XMPPNetwork) handleStanza(s *xmppStream, se xml.StartElement) error {
+	start := time.Now()
+	x.Lock()
+	x.lastReceived = start
+	x.Unlock()
+	switch se.Name.Local {
+	case "iq":
+		iq := xmppIQ{}
+		if err := s.dec.DecodeElement(&iq, &se); err != nil {
+			return err
+		}
+		if iq.Type == "get" && iq.Ping != nil {
+			return x.write("<iq type='result' id='%v' to='%v'/>", xmlEscape(iq.ID), xmlEscape(iq.From))
+		}
+	case "presence":
+		p := xmppPresence{}
+		if err := s.dec.DecodeElement(&p, &se); err != nil {
+			return err
+		}
+		x.Lock()
+		if p.Type == "unavailable" {
+			delete(x.occupants, p.From)
+		} else if p.X != nil && len(p.X.Item.JID) > 0 {
+			x.occupants[p.From] = bareJID(p.X.Item.JID)
+		}
+		x.Unlock()
+	case "message":
+		m := xmppMessage{}
+		if err := s.dec.DecodeElement(&m, &se); err != nil {
+			return err
+		}
+		if inMsg, ok := x.inMsg(m, start); ok {
+			x.messages <- inMsg
+		}
+	default:
+		return s.dec.Skip()
+	}
+	return nil
+}
+
+func (x *XMPPNetwork) inMsg(m xmppMessage, start time.Time) (chat.InMsg, bool) {
+	// room history is replayed with a delay element
+	if len(m.Body) == 0 || m.Delay != nil || m.Type == "error" {
+		return chat.InMsg{}, false
+	}
+	id := m.ID
+	if len(id) == 0 {
+		id = x.newID()
+	}
+	inMsg := chat.InMsg{ID: id, Body: m.Body, ArrivedAt: start, SentAt: start}
+	room, nick := splitJID(m.From)
+	x.RLock()
+	_, isRoom := x.rooms[room]
+	realJID, known := x.occupants[m.From]
+	x.RUnlock()
+	if isRoom {
+		if nick == x.config.Nick {
+			return chat.InMsg{}, false
+		}
+		inMsg.From = nick
+		if known {
+			inMsg.From = realJID
+		}
+		if m.Type == "groupchat" {
+			roomName := x.roomName(room)
+			inMsg.RoomID = &roomName
+		} else if !known {
+			// a private message through the room can only be answered there
+			inMsg.From = m.From
+		}
+		return inMsg, true
+	}
+	inMsg.From = bareJID(m.From)
+	return inMsg, true
+}
+
+func (x *XMPPNetwork) roomName(roomJID string) string {
+	if strings.HasSuffix(roomJID, "@"+x.config.MUCDomain) {
+		return strings.TrimSuffix(roomJID, "@"+x.config.MUCDomain)
+	}
+	return roomJID
+}
+
+func (x *XMPPNetwork) roomJID(name string) string {
+	if strings.Contains(name, "@") {
+		return name
+	}
+	return name + "@" + x.config.MUCDomain
+}
+
+func (x *XMPPNetwork) userJID(name string) string {
+	if strings.Contains(name, "@") {
+		return name
+	}
+	return name + "@" + x.domain
+}
+
+func (x *XMPPNetwork) newID() string {
+	return fmt.Sprintf("bot-%v-%v", time.Now().UnixNano(), atomic.AddUint64(&x.lastID, 1))
+}
+
+func (x *XMPPNetwork) write(format string, args ...interface{}) error {
+	x.RLock()
+	conn := x.conn
+	x.RUnlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+	x.writeMu.Lock()
+	defer x.writeMu.Unlock()
+	_, err := fmt.Fprintf(conn, format, args...)
+	return err
+}
+
+func (x *XMPPNetwork) message(to string, typ string, m chat.OutMsg) error {
+	body := m.Body
+	if m.HTML != nil && *m.HTML {
+		body = htmlToText(body)
+	}
+	return x.write("<message to='%v' type='%v' id='%v'><body>%v</body></message>", xmlEscape(to), typ, x.newID(), xmlEscape(body))
+}
+
+func (x *XMPPNetwork) joinRoom(roomJID string) error {
+	return x.write("<presence to='%v/%v'><x xmlns='%v'><history maxstanzas='0'/></x></presence>", xmlEscape(roomJID), xmlEscape(x.config.Nick), nsMUC)
+}
+
+func (x *XMPPNetwork) NickName() string {
+	return x.config.Nick
+}
+
+func (x *XMPPNetwork) SendPM(m chat.OutMsg) error {
+	return x.message(x.userJID(m.To), "chat", m)
+}
+
+func (x *XMPPNetwork) Send(m chat.OutMsg) error {
+	roomJID := x.roomJID(m.To)
+	x.RLock()
+	_, joined := x.rooms[roomJID]
+	x.RUnlock()
+	if !joined {
+		return fmt.Errorf("Unable to send to room %v before joining it", m.To)
+	}
+	return x.message(roomJID, "groupchat", m)
+}
+
+// JoinRoom joins a room now and again whenever the bot reconnects.
+func (x *XMPPNetwork) JoinRoom(room string) error {
+	roomJID := x.roomJID(room)
+	x.Lock()
+	x.rooms[roomJID] = true
+	x.Unlock()
+	return x.joinRoom(roomJID)
+}
+
+func (x *XMPPNetwork) SetStatus(s string) error {
+	if len(s) == 0 || s == "chat" || s == "available" {
+		return x.write("<presence/>")
+	}
+	return x.write("<presence><show>away</show><status>%v</status></presence>", xmlEscape(s))
+}
+
+func (x *XMPPNetwork) Messages() <-chan chat.InMsg {
+	return x.messages
+}
+
+func (x *XMPPNetwork) OnConnect() <-chan bool {
+	return x.onConnect
+}
+
+func bareJID(jid string) string {
+	return strings.SplitN(jid, "/", 2)[0]
+}
+
+func splitJID(jid string) (string, string) {
+	split := strings.SplitN(jid, "/", 2)
+	if len(split) == 1 {
+		return split[0], ""
+	}
+	return split[0], split[1]
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
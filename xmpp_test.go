@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mackross/go-bot/chat"
+)
+
+type fakeStanza struct {
+	XMLName xml.Name
+	To      string `xml:"to,attr"`
+	Type    string `xml:"type,attr"`
+	ID      string `xml:"id,attr"`
+	Body    string `xml:"body"`
+	Inner   string `xml:",innerxml"`
+}
+
+// fakeXMPP is an in-process XMPP server that supports just enough of the
+// protocol for the bot: SASL PLAIN, resource binding, MUC joins and pings.
+type fakeXMPP struct {
+	listener net.Listener
+	conns    chan net.Conn
+	stanzas  chan fakeStanza
+}
+
+func newFakeXMPP(t *testing.T) *fakeXMPP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	f := &fakeXMPP{l, make(chan net.Conn, 10), make(chan fakeStanza, 100)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.conns <- conn
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeXMPP) serve(conn net.Conn) {
+	defer conn.Close()
+	dec := xml.NewDecoder(conn)
+	authed := false
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return
+		}
+		se, isStart := t.(xml.StartElement)
+		if !isStart {
+			continue
+		}
+		if se.Name.Local == "stream" {
+			fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream from='example.com' xmlns='jabber:client' xmlns:stream='%v' version='1.0'>", nsStream)
+			if authed {
+				fmt.Fprintf(conn, "<stream:features><bind xmlns='%v'/></stream:features>", nsBind)
+			} else {
+				fmt.Fprintf(conn, "<stream:features><mechanisms xmlns='%v'><mechanism>PLAIN</mechanism></mechanisms></stream:features>", nsSASL)
+			}
+			continue
+		}
+		s := fakeStanza{}
+		if err := dec.DecodeElement(&s, &se); err != nil {
+			return
+		}
+		switch {
+		case s.XMLName.Local == "auth":
+			creds, _ := base64.StdEncoding.DecodeString(s.Inner)
+			if string(creds) == "\x00botty\x00hunter2" {
+				authed = true
+				fmt.Fprintf(conn, "<success xmlns='%v'/>", nsSASL)
+			} else {
+				fmt.Fprintf(conn, "<failure xmlns='%v'><not-authorized/></failure>", nsSASL)
+			}
+		case s.XMLName.Local == "iq" && s.ID == "bind":
+			fmt.Fprintf(conn, "<iq type='result' id='bind'><bind xmlns='%v'><jid>botty@example.com/bot</jid></bind></iq>", nsBind)
+		case s.XMLName.Local == "iq" && s.Type == "get":
+			fmt.Fprintf(conn, "<iq type='result' id='%v' from='example.com'/>", s.ID)
+		case s.XMLName.Local == "presence" && s.To == "general@conference.example.com/botty":
+			fmt.Fprintf(conn, "<presence from='general@conference.example.com/alice'><x xmlns='%v'><item jid='alice@example.com/laptop' role='participant'/></x></presence>", nsMUCUser)
+			fmt.Fprintf(conn, "<presence from='general@conference.example.com/botty'><x xmlns='%v'><item jid='botty@example.com/bot' role='participant'/><status code='110'/></x></presence>", nsMUCUser)
+		}
+		f.stanzas <- s
+	}
+}
+
+// expect waits for the client to send a stanza matching match, skipping others.
+func (f *fakeXMPP) expect(t *testing.T, match func(s fakeStanza) bool) fakeStanza {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case s := <-f.stanzas:
+			if match(s) {
+				return s
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for stanza")
+		}
+	}
+}
+
+func nextXMPPMessage(t *testing.T, x *XMPPNetwork) chat.InMsg {
+	select {
+	case m := <-x.Messages():
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return chat.InMsg{}
+}
+
+func TestXMPPJoinsRoomsAndChats(t *testing.T) {
+	f := newFakeXMPP(t)
+	defer f.listener.Close()
+
+	x := XMPPConnect(XMPPConfig{JID: "botty@example.com", Password: "hunter2", Server: f.listener.Addr().String(), NoTLS: true, PingInterval: 50 * time.Millisecond})
+	conn := <-f.conns
+	select {
+	case <-x.OnConnect():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out connecting")
+	}
+	equals(t, "botty", x.NickName())
+
+	ok(t, x.JoinRoom("general"))
+	f.expect(t, func(s fakeStanza) bool {
+		return s.XMLName.Local == "presence" && s.To == "general@conference.example.com/botty"
+	})
+	// keepalive pings are sent and answered
+	f.expect(t, func(s fakeStanza) bool { return s.XMLName.Local == "iq" && s.Type == "get" && s.To == "example.com" })
+
+	fmt.Fprintf(conn, "<message from='general@conference.example.com/alice' type='groupchat' id='h1'><body>old news</body><delay xmlns='urn:xmpp:delay' stamp='2015-01-01T00:00:00Z'/></message>")
+	fmt.Fprintf(conn, "<message from='general@conference.example.com/botty' type='groupchat' id='m0'><body>echo</body></message>")
+	fmt.Fprintf(conn, "<message from='general@conference.example.com/alice' type='groupchat' id='m1'><body>hi &amp; bye</body></message>")
+	m := nextXMPPMessage(t, x)
+	equals(t, "m1", m.ID)
+	equals(t, "alice@example.com", m.From)
+	equals(t, "hi & bye", m.Body)
+	equals(t, "general", *m.RoomID)
+
+	fmt.Fprintf(conn, "<message from='bob@example.com/phone' type='chat'><body>whoami</body></message>")
+	m = nextXMPPMessage(t, x)
+	equals(t, "bob@example.com", m.From)
+	assert(t, m.IsPM(), "expected chat message to be a PM")
+
+	fmt.Fprintf(conn, "<iq type='get' id='p1' from='example.com'><ping xmlns='urn:xmpp:ping'/></iq>")
+	f.expect(t, func(s fakeStanza) bool { return s.XMLName.Local == "iq" && s.ID == "p1" && s.Type == "result" })
+
+	ok(t, x.Send(chat.OutMsg{To: "general", Body: "a < b"}))
+	s := f.expect(t, func(s fakeStanza) bool { return s.XMLName.Local == "message" })
+	equals(t, "general@conference.example.com", s.To)
+	equals(t, "groupchat", s.Type)
+	equals(t, "a < b", s.Body)
+
+	ok(t, x.SendPM(chat.OutMsg{To: m.From, Body: "psst"}))
+	s = f.expect(t, func(s fakeStanza) bool { return s.XMLName.Local == "message" })
+	equals(t, "bob@example.com", s.To)
+	equals(t, "chat", s.Type)
+
+	assert(t, x.Send(chat.OutMsg{To: "random", Body: "hello"}) != nil, "expected error sending to a room that wasn't joined")
+
+	// dropped connections are redialled and rooms rejoined
+	conn.Close()
+	select {
+	case <-f.conns:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for reconnection")
+	}
+	f.expect(t, func(s fakeStanza) bool {
+		return s.XMLName.Local == "presence" && s.To == "general@conference.example.com/botty"
+	})
+}
+
+func TestXMPPRefusesToAuthenticateWithoutTLS(t *testing.T) {
+	f := newFakeXMPP(t)
+	defer f.listener.Close()
+
+	// the fake server never offers STARTTLS, as if it had been stripped
+	XMPPConnect(XMPPConfig{JID: "botty@example.com", Password: "hunter2", Server: f.listener.Addr().String()})
+	select {
+	case <-f.conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for connection")
+	}
+	timeout := time.After(500 * time.Millisecond)
+	for {
+		select {
+		case s := <-f.stanzas:
+			assert(t, s.XMLName.Local != "auth", "expected no credentials sent without TLS")
+		case <-timeout:
+			return
+		}
+	}
+}